@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type authScope string
+
+const (
+	trainScope authScope = "train"
+	calcScope  authScope = "calc"
+	statsScope authScope = "stats"
+
+	// adminScope grants access to all the methods.
+	adminScope authScope = "admin"
+)
+
+// apiKeyConfig describes a single credential accepted by the handlers.
+type apiKeyConfig struct {
+	// Name identifies the key owner in logs; the key itself is never logged.
+	Name   string      `yaml:"name"`
+	Key    string      `yaml:"key"`
+	Scopes []authScope `yaml:"scopes"`
+
+	// Tenant restricts the key to the models of a single tenant unless the key has the admin scope.
+	Tenant string `yaml:"tenant"`
+}
+
+// authConfig stores the credentials accepted by the handlers; authentication is disabled if there are none.
+type authConfig struct {
+	Keys []apiKeyConfig `yaml:"keys"`
+}
+
+var errUnauthenticated = errors.New("missing or invalid API key")
+
+func (c *authConfig) enabled() bool {
+	return len(c.Keys) > 0
+}
+
+func (c *authConfig) validate() error {
+	names := map[string]bool{}
+	for idx, key := range c.Keys {
+		if len(key.Name) == 0 || len(key.Key) == 0 {
+			return fmt.Errorf("API key #%v must have both name and key", idx)
+		}
+		if names[key.Name] {
+			return fmt.Errorf("duplicate API key name: %v", key.Name)
+		}
+		names[key.Name] = true
+		if len(key.Tenant) > 0 && !tenantNameRegexp.MatchString(key.Tenant) {
+			return fmt.Errorf("invalid tenant of API key %v: %q", key.Name, key.Tenant)
+		}
+		for _, scope := range key.Scopes {
+			switch scope {
+			case trainScope, calcScope, statsScope, adminScope:
+			default:
+				return fmt.Errorf("unknown scope of API key %v: %v", key.Name, scope)
+			}
+		}
+	}
+	return nil
+}
+
+// authenticate returns the key matching the given credential.
+func (c *authConfig) authenticate(credential string) (*apiKeyConfig, error) {
+	if len(credential) == 0 {
+		return nil, errUnauthenticated
+	}
+	for idx := range c.Keys {
+		if subtle.ConstantTimeCompare([]byte(c.Keys[idx].Key), []byte(credential)) == 1 {
+			return &c.Keys[idx], nil
+		}
+	}
+	return nil, errUnauthenticated
+}
+
+func (k *apiKeyConfig) allows(scope authScope) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == adminScope {
+			return true
+		}
+	}
+	return false
+}
+
+// credentialFromHeaders extracts the credential from "Authorization: Bearer <token>" or "X-API-Key: <key>".
+func credentialFromHeaders(authorization string, apiKey string) string {
+	if len(apiKey) > 0 {
+		return apiKey
+	}
+	const bearerPrefix = "bearer "
+	if len(authorization) > len(bearerPrefix) && strings.ToLower(authorization[:len(bearerPrefix)]) == bearerPrefix {
+		return strings.TrimSpace(authorization[len(bearerPrefix):])
+	}
+	return ""
+}
+
+type authContextKey struct{}
+
+// authenticatedKey returns the key the request was authenticated with, nil if authentication is disabled.
+func authenticatedKey(ctx context.Context) *apiKeyConfig {
+	key, _ := ctx.Value(authContextKey{}).(*apiKeyConfig)
+	return key
+}
+
+// withAuth makes the handler serve only the requests authenticated with a key having the given scope.
+func withAuth(config *authConfig, scope authScope, handler http.Handler) http.Handler {
+	if !config.enabled() {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		credential := credentialFromHeaders(r.Header.Get("Authorization"), r.Header.Get("X-API-Key"))
+		key, err := config.authenticate(credential)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !key.allows(scope) {
+			http.Error(w, fmt.Sprintf("API key %v has no %v scope", key.Name, scope), http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authContextKey{}, key)))
+	})
+}
+
+// grpcMethodScopes lists the scopes required by the gRPC methods; the methods not listed here are served without authentication.
+var grpcMethodScopes = map[string]authScope{
+	"/" + regressionServiceName + "/Train":             trainScope,
+	"/" + regressionServiceName + "/Calculate":         calcScope,
+	"/" + regressionServiceName + "/GetModel":          calcScope,
+	"/" + regressionServiceName + "/DeleteModel":       trainScope,
+	"/" + regressionServiceName + "/SubmitTrainingJob": trainScope,
+	"/" + regressionServiceName + "/GetJob":            trainScope,
+	"/" + regressionServiceName + "/CancelJob":         trainScope,
+	"/" + regressionServiceName + "/Stats":             statsScope,
+}
+
+func (h *grpcHandler) authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	scope, ok := grpcMethodScopes[fullMethod]
+	if !ok || !h.config.Auth.enabled() {
+		return ctx, nil
+	}
+
+	var authorization, apiKey string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+		if values := md.Get("x-api-key"); len(values) > 0 {
+			apiKey = values[0]
+		}
+	}
+
+	key, err := h.config.Auth.authenticate(credentialFromHeaders(authorization, apiKey))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if !key.allows(scope) {
+		return nil, status.Errorf(codes.PermissionDenied, "API key %v has no %v scope", key.Name, scope)
+	}
+	return context.WithValue(ctx, authContextKey{}, key), nil
+}
+
+func (h *grpcHandler) authUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := h.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (h *grpcHandler) authStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := h.authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+// apiKeyCredentials attaches the client's API key to every gRPC call.
+type apiKeyCredentials struct {
+	apiKey     string
+	requireTLS bool
+}
+
+func (c apiKeyCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + c.apiKey}, nil
+}
+
+func (c apiKeyCredentials) RequireTransportSecurity() bool {
+	return c.requireTLS
+}
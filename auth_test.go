@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	pb "linear_regression_service/github.com/ashagraev/linear_regression"
+)
+
+var testAuthConfig = authConfig{Keys: []apiKeyConfig{
+	{Name: "trainer", Key: "train-key", Scopes: []authScope{trainScope}},
+	{Name: "reader", Key: "calc-key", Scopes: []authScope{calcScope, statsScope}},
+	{Name: "ops", Key: "admin-key", Scopes: []authScope{adminScope}},
+}}
+
+func TestWithAuth(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        authConfig
+		scope         authScope
+		authorization string
+		apiKey        string
+		wantStatus    int
+		wantKey       string
+	}{
+		{"disabled", authConfig{}, trainScope, "", "", http.StatusOK, ""},
+		{"missing credential", testAuthConfig, trainScope, "", "", http.StatusUnauthorized, ""},
+		{"unknown key", testAuthConfig, trainScope, "Bearer wrong", "", http.StatusUnauthorized, ""},
+		{"not a bearer token", testAuthConfig, trainScope, "Basic train-key", "", http.StatusUnauthorized, ""},
+		{"bearer token", testAuthConfig, trainScope, "Bearer train-key", "", http.StatusOK, "trainer"},
+		{"lowercase bearer token", testAuthConfig, trainScope, "bearer  train-key ", "", http.StatusOK, "trainer"},
+		{"api key header", testAuthConfig, calcScope, "", "calc-key", http.StatusOK, "reader"},
+		{"api key header wins", testAuthConfig, calcScope, "Bearer train-key", "calc-key", http.StatusOK, "reader"},
+		{"missing scope", testAuthConfig, trainScope, "", "calc-key", http.StatusForbidden, ""},
+		{"admin scope", testAuthConfig, statsScope, "Bearer admin-key", "", http.StatusOK, "ops"},
+	}
+	for _, test := range tests {
+		var gotKey *apiKeyConfig
+		handler := withAuth(&test.config, test.scope, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotKey = authenticatedKey(r.Context())
+		}))
+		r := httptest.NewRequest(http.MethodGet, "/stats", nil)
+		if len(test.authorization) > 0 {
+			r.Header.Set("Authorization", test.authorization)
+		}
+		if len(test.apiKey) > 0 {
+			r.Header.Set("X-API-Key", test.apiKey)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != test.wantStatus {
+			t.Errorf("%v: status %v, want %v", test.name, w.Code, test.wantStatus)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%v: no WWW-Authenticate challenge", test.name)
+		}
+		gotName := ""
+		if gotKey != nil {
+			gotName = gotKey.Name
+		}
+		if gotName != test.wantKey {
+			t.Errorf("%v: authenticated as %q, want %q", test.name, gotName, test.wantKey)
+		}
+	}
+}
+
+func TestGRPCMethodScopesCoverService(t *testing.T) {
+	methods := pb.File_regression_proto.Services().ByName("Regression").Methods()
+	for i := 0; i < methods.Len(); i++ {
+		fullMethod := "/" + regressionServiceName + "/" + string(methods.Get(i).Name())
+		if _, ok := grpcMethodScopes[fullMethod]; !ok {
+			t.Errorf("method %v has no scope and is served without authentication", fullMethod)
+		}
+	}
+}
+
+func TestGRPCAuthorize(t *testing.T) {
+	h := &grpcHandler{config: &handlerConfig{Auth: testAuthConfig}}
+	tests := []struct {
+		name     string
+		method   string
+		metadata []string
+		wantCode codes.Code
+		wantKey  string
+	}{
+		{"unlisted method", "/grpc.health.v1.Health/Check", nil, codes.OK, ""},
+		{"missing credential", "/" + regressionServiceName + "/Train", nil, codes.Unauthenticated, ""},
+		{"unknown key", "/" + regressionServiceName + "/Train", []string{"authorization", "Bearer wrong"}, codes.Unauthenticated, ""},
+		{"bearer token", "/" + regressionServiceName + "/Train", []string{"authorization", "Bearer train-key"}, codes.OK, "trainer"},
+		{"api key", "/" + regressionServiceName + "/GetModel", []string{"x-api-key", "calc-key"}, codes.OK, "reader"},
+		{"missing scope", "/" + regressionServiceName + "/DeleteModel", []string{"x-api-key", "calc-key"}, codes.PermissionDenied, ""},
+		{"admin scope", "/" + regressionServiceName + "/CancelJob", []string{"authorization", "Bearer admin-key"}, codes.OK, "ops"},
+	}
+	for _, test := range tests {
+		ctx := context.Background()
+		if test.metadata != nil {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(test.metadata...))
+		}
+		ctx, err := h.authorize(ctx, test.method)
+		if status.Code(err) != test.wantCode {
+			t.Errorf("%v: code %v, want %v", test.name, status.Code(err), test.wantCode)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		gotName := ""
+		if key := authenticatedKey(ctx); key != nil {
+			gotName = key.Name
+		}
+		if gotName != test.wantKey {
+			t.Errorf("%v: authenticated as %q, want %q", test.name, gotName, test.wantKey)
+		}
+	}
+}
+
+func TestAuthConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []apiKeyConfig
+		wantErr bool
+	}{
+		{"valid", testAuthConfig.Keys, false},
+		{"missing key", []apiKeyConfig{{Name: "a"}}, true},
+		{"duplicate name", []apiKeyConfig{{Name: "a", Key: "1"}, {Name: "a", Key: "2"}}, true},
+		{"unknown scope", []apiKeyConfig{{Name: "a", Key: "1", Scopes: []authScope{"write"}}}, true},
+		{"invalid tenant", []apiKeyConfig{{Name: "a", Key: "1", Tenant: "a/b"}}, true},
+	}
+	for _, test := range tests {
+		config := authConfig{Keys: test.keys}
+		if err := config.validate(); (err != nil) != test.wantErr {
+			t.Errorf("%v: validate() = %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+}
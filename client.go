@@ -1,13 +1,31 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
+	"time"
+
+	pb "linear_regression_service/github.com/ashagraev/linear_regression"
 )
 
 type regressionClient struct {
 	serverPath string
 	modelName string
+	tenant string
+	timeout time.Duration
+	apiKey string
+	idempotencyKey string
+	invalidInstances validationPolicy
+	input string
+	format delimitedFormat
+
+	// tlsConfig is nil for plain text connections.
+	tlsConfig *tls.Config
+	httpClient *http.Client
 }
 
 type operationMode int
@@ -16,16 +34,38 @@ const (
 	calculateMode operationMode = iota
 	trainMode
 	statsMode
+
+	// getModelMode and deleteModelMode are served by the gRPC service and the http handler's versioned API.
+	getModelMode
+	deleteModelMode
+
+	// describeMode lists the gRPC services and message schemas via the server reflection.
+	describeMode
+
+	// submitJobMode, getJobMode and cancelJobMode are the asynchronous training jobs' methods of the gRPC service and the versioned API.
+	submitJobMode
+	getJobMode
+	cancelJobMode
 )
 
-func clientMode(operation operationMode, protocol protocolMode) string {
-	operationStr := ""
+func operationName(operation operationMode) string {
 	switch operation {
-	case calculateMode: operationStr = "calc"
-	case trainMode: operationStr = "train"
-	case statsMode: operationStr = "stats"
+	case calculateMode: return "calc"
+	case trainMode: return "train"
+	case statsMode: return "stats"
+	case getModelMode: return "get_model"
+	case deleteModelMode: return "delete_model"
+	case describeMode: return "describe"
+	case submitJobMode: return "submit_job"
+	case getJobMode: return "get_job"
+	case cancelJobMode: return "cancel_job"
 	}
-	return protocolPrefix(protocol) + "-" + operationStr
+	log.Fatalf("unknown operation mode: %v", operation)
+	return ""
+}
+
+func clientMode(operation operationMode, protocol protocolMode) string {
+	return protocolPrefix(protocol) + "-" + operationName(operation)
 }
 
 func clientModeArg(operation operationMode, protocol protocolMode) string {
@@ -37,6 +77,7 @@ func clientUsage(operation operationMode) string {
 	case calculateMode: return "calculate model value"
 	case trainMode: return "train model"
 	case statsMode: return "collect service execution stats"
+	case describeMode: return "list services and message schemas"
 	}
 	log.Fatalf("unknown operation mode: %v", operation)
 	return ""
@@ -44,9 +85,89 @@ func clientUsage(operation operationMode) string {
 
 func newRegressionClient(operation operationMode, protocol protocolMode) *regressionClient {
 	flag.Bool(clientMode(operation, protocol), true, clientUsage(operation))
-	var server = flag.String("server", "", "network path of the training server")
-	var model = flag.String("model", "", "model name for calculation")
-	flag.Parse()
 
-	return &regressionClient{serverPath: *server, modelName: *model}
+	config := defaultServiceConfig()
+	cc := &config.Client
+	flag.StringVar(&cc.Server, "server", cc.Server, "network path of the training server")
+	flag.StringVar(&cc.Model, "model", cc.Model, "model name for calculation")
+	flag.StringVar(&cc.Tenant, "tenant", cc.Tenant, "tenant owning the models, taken from the API key if empty")
+	flag.DurationVar(&cc.Timeout, "timeout", cc.Timeout, "maximum time to wait for a single request, 0 for no limit")
+	flag.BoolVar(&cc.TLS.Enabled, "tls", cc.TLS.Enabled, "use TLS for grpc connections")
+	flag.StringVar(&cc.TLS.CAFile, "tls-ca", cc.TLS.CAFile, "CA file to verify the server certificate")
+	flag.StringVar(&cc.TLS.ServerName, "tls-server-name", cc.TLS.ServerName, "expected server name in the server certificate")
+	flag.StringVar(&cc.TLS.CertFile, "tls-cert", cc.TLS.CertFile, "client certificate file for mutual TLS")
+	flag.StringVar(&cc.TLS.KeyFile, "tls-key", cc.TLS.KeyFile, "client private key file for mutual TLS")
+	flag.StringVar(&cc.APIKey, "api-key", cc.APIKey, "API key to authenticate the requests with")
+	flag.StringVar(&cc.IdempotencyKey, "idempotency-key", cc.IdempotencyKey, "idempotency key of the training request storing the model")
+	flag.Var(&cc.InvalidInstances, "invalid-instances", "handling of invalid training instances: reject, skip or clamp")
+	flag.StringVar(&cc.Input, "input", cc.Input, "training data file, optionally gzip or zstd compressed; stdin if empty")
+	flag.StringVar(&cc.Format.Delimiter, "delimiter", cc.Format.Delimiter, "column delimiter of the training data, any whitespace if empty")
+	flag.BoolVar(&cc.Format.Header, "header", cc.Format.Header, "skip the header line of the training data")
+	flag.StringVar(&cc.Format.Comment, "comment", cc.Format.Comment, "prefix of the comment lines of the training data")
+	flag.StringVar(&cc.Format.FeatureColumn, "feature-column", cc.Format.FeatureColumn, "feature column index or name, 0 by default")
+	flag.StringVar(&cc.Format.TargetColumn, "target-column", cc.Format.TargetColumn, "target column index or name, 1 by default")
+	flag.StringVar(&cc.Format.WeightColumn, "weight-column", cc.Format.WeightColumn, "weight column index or name")
+	configFlags := []string{"server", "model", "tenant", "timeout", "tls", "tls-ca", "tls-server-name", "tls-cert", "tls-key", "api-key",
+		"idempotency-key", "invalid-instances", "input", "delimiter", "header", "comment", "feature-column", "target-column", "weight-column"}
+	if err := parseConfig(&config, configFlags); err != nil {
+		log.Fatal("cannot load config: ", err)
+	}
+	if err := cc.InvalidInstances.validate(); err != nil {
+		log.Fatal("cannot load config: ", err)
+	}
+
+	rc := &regressionClient{
+		serverPath: cc.Server,
+		modelName: cc.Model,
+		tenant: cc.Tenant,
+		timeout: cc.Timeout,
+		apiKey: cc.APIKey,
+		idempotencyKey: cc.IdempotencyKey,
+		invalidInstances: cc.InvalidInstances,
+		input: cc.Input,
+		format: cc.Format,
+		httpClient: &http.Client{Timeout: cc.Timeout},
+	}
+
+	if cc.TLS.enabled() {
+		tlsConfig, err := cc.TLS.newTLSConfig()
+		if err != nil {
+			log.Fatal("cannot configure TLS: ", err)
+		}
+		rc.tlsConfig = tlsConfig
+		rc.httpClient.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	return rc
+}
+
+// loadTrainingInstances loads the training data from the client's input according to its format
+// and logs the invalid instances dropped or clamped while loading.
+func (rc *regressionClient) loadTrainingInstances() ([]*pb.Instance, error) {
+	input, err := openTrainingInput(rc.input)
+	if err != nil {
+		return nil, err
+	}
+	defer input.close()
+	return rc.loadInputInstances(input)
+}
+
+// loadInputInstances loads the training data from the opened input, see loadTrainingInstances.
+func (rc *regressionClient) loadInputInstances(input *trainingInput) ([]*pb.Instance, error) {
+	validator := newInstanceValidator(rc.invalidInstances)
+	instances, err := loadInstances(input.reader, &rc.format, validator)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", input.name, err)
+	}
+	if validator.droppedInstances > 0 || validator.clampedInstances > 0 {
+		log.Print(validator)
+	}
+	return instances, nil
+}
+
+// requestContext limits the lifetime of a single request according to the client's timeout.
+func (rc *regressionClient) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if rc.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, rc.timeout)
 }
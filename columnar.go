@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"runtime/debug"
+	"strings"
+
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// columnarKind chooses the columnar training data format.
+type columnarKind int
+
+const (
+	parquetKind columnarKind = iota
+	arrowFileKind
+	arrowStreamKind
+)
+
+var (
+	parquetMagic     = []byte("PAR1")
+	arrowFileMagic   = []byte("ARROW1")
+	arrowStreamMagic = []byte{0xff, 0xff, 0xff, 0xff}
+)
+
+// detectColumnarKind checks the magic bytes the columnar files start with.
+func detectColumnarKind(magic []byte) (columnarKind, bool) {
+	switch {
+	case bytes.HasPrefix(magic, parquetMagic):
+		return parquetKind, true
+	case bytes.HasPrefix(magic, arrowFileMagic):
+		return arrowFileKind, true
+	case bytes.HasPrefix(magic, arrowStreamMagic):
+		return arrowStreamKind, true
+	}
+	return 0, false
+}
+
+// columnarMediaType returns the content type the http handler accepts the columnar training data with.
+func columnarMediaType(kind columnarKind) string {
+	switch kind {
+	case arrowFileKind:
+		return "application/vnd.apache.arrow.file"
+	case arrowStreamKind:
+		return "application/vnd.apache.arrow.stream"
+	}
+	return "application/vnd.apache.parquet"
+}
+
+// resolveColumnarColumns chooses the columns by the schema's field names; by default the first two fields
+// are the feature and the target, and the third one, if any, is the weight.
+func (f *delimitedFormat) resolveColumnarColumns(names []string) (*instanceColumns, error) {
+	columns, err := f.resolveColumns(names)
+	if err != nil {
+		return nil, err
+	}
+	if columns.legacy && len(names) < 3 {
+		columns.weight = -1
+	}
+	for _, column := range columns.indices() {
+		if column >= len(names) {
+			return nil, fmt.Errorf("no column %v, the schema has %v columns", column, len(names))
+		}
+	}
+	return columns, nil
+}
+
+// indices lists the feature, target and weight columns; the weight column is -1 if it is not chosen.
+func (c *instanceColumns) indices() []int {
+	return []int{c.feature, c.target, c.weight}
+}
+
+// parquetBatchRows limits the number of rows read from the parquet columns at once, so that the memory used
+// does not depend on the size of the row groups.
+const parquetBatchRows = 4096
+
+// readColumnarInstances reads the instances from a Parquet file or an Arrow IPC file or stream
+// and passes them to consume batch by batch, or record batch by record batch.
+// Null values are passed as NaN, so that they are handled by the validation.
+func readColumnarInstances(kind columnarKind, r io.Reader, spoolDir string, format *delimitedFormat, consume func(position int, instance []float64) error) (int, error) {
+	if kind == arrowStreamKind {
+		return readArrowStreamInstances(r, format, consume)
+	}
+
+	// Parquet and Arrow files keep their metadata at the end, so they are read from a file rather than a stream:
+	// the spooled uploads and the client's input files are read as is, the other data is spooled to the spool directory.
+	file, ok := r.(*os.File)
+	if !ok {
+		var err error
+		if file, err = spoolColumnarData(spoolDir, r); err != nil {
+			return 0, err
+		}
+		defer os.Remove(file.Name())
+		defer file.Close()
+	}
+	if kind == arrowFileKind {
+		return readArrowFileInstances(file, format, consume)
+	}
+	return readParquetInstances(file, format, consume)
+}
+
+func spoolColumnarData(dir string, r io.Reader) (*os.File, error) {
+	file, err := os.CreateTemp(dir, "training-data-*")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create temporary file: %v", err)
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("cannot read instances: %w", err)
+	}
+	return file, nil
+}
+
+func parquetValue(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case nil:
+		return math.NaN(), nil
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	}
+	return 0, fmt.Errorf("value of type %T is not numeric", value)
+}
+
+// parquetCall runs the parquet library call, turning its panics on malformed files into errors.
+func parquetCall(call func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("parquet reader panicked: %v\n%s", r, debug.Stack())
+			err = fmt.Errorf("malformed parquet file: %v", r)
+		}
+	}()
+	return call()
+}
+
+func readParquetInstances(file *os.File, format *delimitedFormat, consume func(position int, instance []float64) error) (int, error) {
+	// The column readers reopen the file by its name.
+	var pr *reader.ParquetReader
+	err := parquetCall(func() (err error) {
+		pr, err = reader.NewParquetColumnReader(&local.LocalFile{FilePath: file.Name(), File: file}, 1)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("cannot read parquet file: %v", err)
+	}
+	defer parquetCall(func() error {
+		pr.ReadStop()
+		return nil
+	})
+
+	var names []string
+	for _, inPath := range pr.SchemaHandler.ValueColumns {
+		names = append(names, strings.Join(common.StrToPath(pr.SchemaHandler.InPathToExPath[inPath])[1:], "."))
+	}
+	columns, err := format.resolveColumnarColumns(names)
+	if err != nil {
+		return 0, err
+	}
+
+	// Every column is read once even if it is chosen several times, e.g. as both the feature and the target.
+	var uniqueColumns []int
+	valueIndices := make([]int, 0, 3)
+	for _, column := range columns.indices() {
+		if column < 0 {
+			continue
+		}
+		valueIdx := len(uniqueColumns)
+		for idx, unique := range uniqueColumns {
+			if unique == column {
+				valueIdx = idx
+			}
+		}
+		if valueIdx == len(uniqueColumns) {
+			uniqueColumns = append(uniqueColumns, column)
+		}
+		valueIndices = append(valueIndices, valueIdx)
+	}
+
+	count := 0
+	values := make([][]interface{}, len(uniqueColumns))
+	for _, rowGroup := range pr.Footer.RowGroups {
+		for left := int(rowGroup.NumRows); left > 0; {
+			rows := left
+			if rows > parquetBatchRows {
+				rows = parquetBatchRows
+			}
+			left -= rows
+
+			for idx, column := range uniqueColumns {
+				var columnValues []interface{}
+				err := parquetCall(func() (err error) {
+					columnValues, _, _, err = pr.ReadColumnByIndex(int64(column), int64(rows))
+					return err
+				})
+				if err != nil {
+					return count, fmt.Errorf("cannot read parquet column %v: %v", names[column], err)
+				}
+				if len(columnValues) != rows {
+					return count, fmt.Errorf("parquet column %v must contain a single value per row", names[column])
+				}
+				values[idx] = columnValues
+			}
+
+			for row := 0; row < rows; row++ {
+				instance := make([]float64, 0, len(valueIndices))
+				for _, valueIdx := range valueIndices {
+					v, err := parquetValue(values[valueIdx][row])
+					if err != nil {
+						return count, fmt.Errorf("%v, row %v", err, count)
+					}
+					instance = append(instance, v)
+				}
+				if err := consume(count, instance); err != nil {
+					return count, err
+				}
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+func arrowValue(column array.Interface, row int) (float64, error) {
+	if column.IsNull(row) {
+		return math.NaN(), nil
+	}
+	switch c := column.(type) {
+	case *array.Float64:
+		return c.Value(row), nil
+	case *array.Float32:
+		return float64(c.Value(row)), nil
+	case *array.Int64:
+		return float64(c.Value(row)), nil
+	case *array.Int32:
+		return float64(c.Value(row)), nil
+	case *array.Int16:
+		return float64(c.Value(row)), nil
+	case *array.Int8:
+		return float64(c.Value(row)), nil
+	case *array.Uint64:
+		return float64(c.Value(row)), nil
+	case *array.Uint32:
+		return float64(c.Value(row)), nil
+	case *array.Uint16:
+		return float64(c.Value(row)), nil
+	case *array.Uint8:
+		return float64(c.Value(row)), nil
+	}
+	return 0, fmt.Errorf("column of type %v is not numeric", column.DataType())
+}
+
+func arrowFieldNames(record array.Record) []string {
+	var names []string
+	for _, field := range record.Schema().Fields() {
+		names = append(names, field.Name)
+	}
+	return names
+}
+
+// consumeArrowRecord passes the rows of a single record batch to consume.
+func consumeArrowRecord(record array.Record, columns *instanceColumns, count int, consume func(position int, instance []float64) error) (int, error) {
+	for row := 0; row < int(record.NumRows()); row++ {
+		instance := make([]float64, 0, 3)
+		for _, column := range columns.indices() {
+			if column < 0 {
+				continue
+			}
+			v, err := arrowValue(record.Column(column), row)
+			if err != nil {
+				return count, fmt.Errorf("%v, row %v", err, count)
+			}
+			instance = append(instance, v)
+		}
+		if err := consume(count, instance); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func readArrowStreamInstances(r io.Reader, format *delimitedFormat, consume func(position int, instance []float64) error) (int, error) {
+	arrowReader, err := ipc.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read arrow stream: %w", err)
+	}
+	defer arrowReader.Release()
+
+	count := 0
+	var columns *instanceColumns
+	for arrowReader.Next() {
+		record := arrowReader.Record()
+		if columns == nil {
+			if columns, err = format.resolveColumnarColumns(arrowFieldNames(record)); err != nil {
+				return count, err
+			}
+		}
+		if count, err = consumeArrowRecord(record, columns, count, consume); err != nil {
+			return count, err
+		}
+	}
+	if err := arrowReader.Err(); err != nil {
+		return count, fmt.Errorf("cannot read arrow stream: %w", err)
+	}
+	return count, nil
+}
+
+func readArrowFileInstances(file *os.File, format *delimitedFormat, consume func(position int, instance []float64) error) (int, error) {
+	arrowReader, err := ipc.NewFileReader(file)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read arrow file: %v", err)
+	}
+	defer arrowReader.Close()
+
+	count := 0
+	var columns *instanceColumns
+	for idx := 0; idx < arrowReader.NumRecords(); idx++ {
+		record, err := arrowReader.Record(idx)
+		if err != nil {
+			return count, fmt.Errorf("cannot read arrow record batch #%v: %v", idx, err)
+		}
+		if columns == nil {
+			if columns, err = format.resolveColumnarColumns(arrowFieldNames(record)); err != nil {
+				return count, err
+			}
+		}
+		if count, err = consumeArrowRecord(record, columns, count, consume); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
+	"github.com/xitongsys/parquet-go-source/writerfile"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+type parquetTestRow struct {
+	X float64  `parquet:"name=x, type=DOUBLE"`
+	Y int64    `parquet:"name=y, type=INT64"`
+	W *float64 `parquet:"name=w, type=DOUBLE, repetitiontype=OPTIONAL"`
+}
+
+// parquetTestData stores the rows (i, 2i + 1) with the weight 1 and every tenth weight missing.
+func parquetTestData(t *testing.T, rows int) []byte {
+	var buf bytes.Buffer
+	pw, err := writer.NewParquetWriter(writerfile.NewWriterFile(&buf), new(parquetTestRow), 1)
+	if err != nil {
+		t.Fatalf("cannot create parquet writer: %v", err)
+	}
+	pw.RowGroupSize = 64 * 1024
+	for i := 0; i < rows; i++ {
+		row := parquetTestRow{X: float64(i), Y: int64(2 * i + 1)}
+		if i % 10 != 0 {
+			weight := 1.0
+			row.W = &weight
+		}
+		if err := pw.Write(row); err != nil {
+			t.Fatalf("cannot write parquet row: %v", err)
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		t.Fatalf("cannot write parquet file: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func readColumnar(t *testing.T, kind columnarKind, r io.Reader, spoolDir string, format delimitedFormat) ([][]float64, []int, error) {
+	var instances [][]float64
+	var positions []int
+	count, err := readColumnarInstances(kind, r, spoolDir, &format, func(position int, instance []float64) error {
+		instances = append(instances, append([]float64(nil), instance...))
+		positions = append(positions, position)
+		return nil
+	})
+	if count != len(instances) {
+		t.Errorf("readColumnarInstances() counted %v instances, consumed %v", count, len(instances))
+	}
+	return instances, positions, err
+}
+
+func TestReadParquetInstances(t *testing.T) {
+	const rows = 2 * parquetBatchRows + 10
+	data := parquetTestData(t, rows)
+
+	tests := []struct {
+		name   string
+		format delimitedFormat
+		want   func(i int) []float64
+	}{
+		{"default columns", delimitedFormat{}, func(i int) []float64 {
+			if i % 10 == 0 {
+				return []float64{float64(i), float64(2 * i + 1), math.NaN()}
+			}
+			return []float64{float64(i), float64(2 * i + 1), 1}
+		}},
+		{"columns by name", delimitedFormat{FeatureColumn: "y", TargetColumn: "x"}, func(i int) []float64 {
+			return []float64{float64(2 * i + 1), float64(i)}
+		}},
+		{"same feature and target column", delimitedFormat{FeatureColumn: "x", TargetColumn: "x"}, func(i int) []float64 {
+			return []float64{float64(i), float64(i)}
+		}},
+	}
+	for _, test := range tests {
+		spoolDir := t.TempDir()
+		instances, positions, err := readColumnar(t, parquetKind, bytes.NewReader(data), spoolDir, test.format)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.name, err)
+			continue
+		}
+		if len(instances) != rows {
+			t.Errorf("%v: %v instances, want %v", test.name, len(instances), rows)
+			continue
+		}
+		for i, instance := range instances {
+			want := test.want(i)
+			if positions[i] != i || len(instance) != len(want) || instance[0] != want[0] || instance[1] != want[1] ||
+				len(want) == 3 && !(instance[2] == want[2] || math.IsNaN(instance[2]) && math.IsNaN(want[2])) {
+				t.Errorf("%v: row %v is %v at %v, want %v", test.name, i, instance, positions[i], want)
+				break
+			}
+		}
+		if spooled, _ := filepath.Glob(filepath.Join(spoolDir, "*")); len(spooled) != 0 {
+			t.Errorf("%v: spooled files are left: %v", test.name, spooled)
+		}
+	}
+}
+
+func TestReadParquetInstancesErrors(t *testing.T) {
+	data := parquetTestData(t, 10)
+	tests := []struct {
+		name   string
+		data   []byte
+		format delimitedFormat
+	}{
+		{"truncated file", data[:len(data) / 2], delimitedFormat{}},
+		{"not a parquet file", []byte("PAR1 is not enough"), delimitedFormat{}},
+		{"unknown column", data, delimitedFormat{FeatureColumn: "z"}},
+	}
+	for _, test := range tests {
+		if _, _, err := readColumnar(t, parquetKind, bytes.NewReader(test.data), t.TempDir(), test.format); err == nil {
+			t.Errorf("%v: no error", test.name)
+		}
+	}
+}
+
+func TestReadParquetInstancesPropagatesConsumePanics(t *testing.T) {
+	defer func() {
+		if r := recover(); r != "consume failed" {
+			t.Errorf("recovered %v, want the consume panic", r)
+		}
+	}()
+	readColumnarInstances(parquetKind, bytes.NewReader(parquetTestData(t, 10)), t.TempDir(), &delimitedFormat{},
+		func(position int, instance []float64) error {
+			panic("consume failed")
+		})
+}
+
+// arrowTestRecord stores the rows (i, 2i + 1) with a null target in every fifth row.
+func arrowTestRecord(rows int) array.Record {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "x", Type: arrow.PrimitiveTypes.Float32},
+		{Name: "y", Type: arrow.PrimitiveTypes.Int32, Nullable: true},
+	}, nil)
+	builder := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	defer builder.Release()
+	for i := 0; i < rows; i++ {
+		builder.Field(0).(*array.Float32Builder).Append(float32(i))
+		if i % 5 == 4 {
+			builder.Field(1).AppendNull()
+		} else {
+			builder.Field(1).(*array.Int32Builder).Append(int32(2 * i + 1))
+		}
+	}
+	return builder.NewRecord()
+}
+
+func arrowTestInstances(batches int, rows int) [][]float64 {
+	var instances [][]float64
+	for batch := 0; batch < batches; batch++ {
+		for i := 0; i < rows; i++ {
+			target := float64(2 * i + 1)
+			if i % 5 == 4 {
+				target = math.NaN()
+			}
+			instances = append(instances, []float64{float64(i), target})
+		}
+	}
+	return instances
+}
+
+func sameInstances(a [][]float64, b [][]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+		for j := range a[i] {
+			if a[i][j] != b[i][j] && !(math.IsNaN(a[i][j]) && math.IsNaN(b[i][j])) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestReadArrowInstances(t *testing.T) {
+	record := arrowTestRecord(7)
+	defer record.Release()
+	want := arrowTestInstances(2, 7)
+	wantPositions := make([]int, len(want))
+	for i := range wantPositions {
+		wantPositions[i] = i
+	}
+
+	var stream bytes.Buffer
+	streamWriter := ipc.NewWriter(&stream, ipc.WithSchema(record.Schema()))
+	for i := 0; i < 2; i++ {
+		if err := streamWriter.Write(record); err != nil {
+			t.Fatalf("cannot write arrow stream: %v", err)
+		}
+	}
+	streamWriter.Close()
+
+	file, err := os.CreateTemp(t.TempDir(), "arrow-*")
+	if err != nil {
+		t.Fatalf("cannot create arrow file: %v", err)
+	}
+	defer file.Close()
+	fileWriter, err := ipc.NewFileWriter(file, ipc.WithSchema(record.Schema()))
+	if err != nil {
+		t.Fatalf("cannot create arrow file writer: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := fileWriter.Write(record); err != nil {
+			t.Fatalf("cannot write arrow file: %v", err)
+		}
+	}
+	if err := fileWriter.Close(); err != nil {
+		t.Fatalf("cannot write arrow file: %v", err)
+	}
+	fileData, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatalf("cannot read arrow file: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		kind columnarKind
+		r    io.Reader
+	}{
+		{"stream", arrowStreamKind, bytes.NewReader(stream.Bytes())},
+		{"spooled file", arrowFileKind, bytes.NewReader(fileData)},
+		{"file", arrowFileKind, file},
+	}
+	for _, test := range tests {
+		instances, positions, err := readColumnar(t, test.kind, test.r, t.TempDir(), delimitedFormat{FeatureColumn: "x", TargetColumn: "y"})
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !sameInstances(instances, want) || !reflect.DeepEqual(positions, wantPositions) {
+			t.Errorf("%v: instances %v at %v, want %v at %v", test.name, instances, positions, want, wantPositions)
+		}
+	}
+}
+
+func TestLoadInstancesReadsColumnarFilesInPlace(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "parquet-*")
+	if err != nil {
+		t.Fatalf("cannot create parquet file: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(parquetTestData(t, 100)); err != nil {
+		t.Fatalf("cannot write parquet file: %v", err)
+	}
+	file.Seek(0, io.SeekStart)
+
+	if input := columnarInput(file, nil); input != io.Reader(file) {
+		t.Errorf("regular file is not read in place")
+	}
+	validator := newInstanceValidator(skipPolicy)
+	instances, err := loadInstances(file, &delimitedFormat{}, validator)
+	if err != nil {
+		t.Fatalf("loadInstances() error: %v", err)
+	}
+	if len(instances) != 90 || validator.droppedInstances != 10 {
+		t.Errorf("loaded %v instances and dropped %v, want 90 and 10", len(instances), validator.droppedInstances)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// storageConfig stores the Spanner database location and the models cache settings.
+type storageConfig struct {
+	Project  string `yaml:"spanner_project"`
+	Instance string `yaml:"spanner_instance"`
+	Database string `yaml:"spanner_database"`
+
+	// MaxCache limits the number of models kept in the local cache.
+	MaxCache int `yaml:"max_cache"`
+
+	// IdempotencyTTL is the time the idempotency keys of the training requests are kept for.
+	IdempotencyTTL time.Duration `yaml:"idempotency_ttl"`
+
+	// ContentAddressedNames derives the model names from the model parameters and the training data fingerprint,
+	// so that identical training runs store a single model; the names are random otherwise.
+	ContentAddressedNames bool `yaml:"content_addressed_names"`
+}
+
+// jobsConfig stores the settings of the asynchronous training jobs.
+type jobsConfig struct {
+	// Workers limits the number of jobs trained concurrently, QueueSize the number of jobs waiting for a worker.
+	Workers   int `yaml:"workers"`
+	QueueSize int `yaml:"queue_size"`
+
+	// Timeout limits the processing time of a single job; zero means no limit.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Retention is the time the finished jobs are kept for polling.
+	Retention time.Duration `yaml:"retention"`
+
+	// SpoolDir keeps the uploaded training data of the jobs until it is trained on and the uploaded Parquet and Arrow files
+	// while they are decoded; the system temporary directory is used if empty.
+	SpoolDir string `yaml:"spool_dir"`
+}
+
+// handlerConfig stores the settings of the http and gRPC handlers.
+type handlerConfig struct {
+	Storage storageConfig   `yaml:"storage"`
+	TLS     serverTLSConfig `yaml:"tls"`
+	Auth    authConfig      `yaml:"auth"`
+
+	// Port is used by the http handler, Address and MetricsAddress are used by the gRPC handler.
+	Port           string `yaml:"port"`
+	Address        string `yaml:"address"`
+	MetricsAddress string `yaml:"metrics_address"`
+
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	RequestTimeout  time.Duration `yaml:"request_timeout"`
+
+	// MaxRequestBytes limits the size of a single request's body.
+	MaxRequestBytes int64 `yaml:"max_request_bytes"`
+
+	// MaxInstances limits the number of instances in a single training request; zero means no limit.
+	MaxInstances int          `yaml:"max_instances"`
+	Limits       limitsConfig `yaml:"limits"`
+	Jobs         jobsConfig   `yaml:"jobs"`
+
+	// InvalidInstances chooses how the training instances with NaN, infinite values or negative weights are handled.
+	InvalidInstances validationPolicy `yaml:"invalid_instances"`
+}
+
+// clientConfig stores the settings of the http and gRPC clients.
+type clientConfig struct {
+	Server  string        `yaml:"server"`
+	Model   string        `yaml:"model"`
+	Tenant  string        `yaml:"tenant"`
+	Timeout time.Duration `yaml:"timeout"`
+
+	TLS    clientTLSConfig `yaml:"tls"`
+	APIKey string          `yaml:"api_key"`
+
+	// IdempotencyKey is sent with the training requests, so that rerunning a timed out training stores no duplicate model.
+	IdempotencyKey string `yaml:"idempotency_key"`
+
+	// Input is the training data file, stdin is read if it is empty.
+	Input string `yaml:"input"`
+
+	// Format describes the layout of the training data file.
+	Format delimitedFormat `yaml:"format"`
+
+	// InvalidInstances chooses how the invalid instances of the training data file are handled.
+	InvalidInstances validationPolicy `yaml:"invalid_instances"`
+}
+
+// serviceConfig is the layout of the configuration file; a single file may configure both the handler and the client.
+type serviceConfig struct {
+	Handler handlerConfig `yaml:"handler"`
+	Client  clientConfig  `yaml:"client"`
+}
+
+const configEnvPrefix = "LRS_"
+
+func defaultServiceConfig() serviceConfig {
+	return serviceConfig{
+		Handler: handlerConfig{
+			Storage:          storageConfig{MaxCache: 100, IdempotencyTTL: 24 * time.Hour},
+			Port:             "8080",
+			Address:          "localhost:8081",
+			MetricsAddress:   "localhost:8082",
+			ShutdownTimeout:  30 * time.Second,
+			RequestTimeout:   time.Minute,
+			MaxRequestBytes:  64 << 20,
+			InvalidInstances: rejectPolicy,
+			Jobs:             jobsConfig{Workers: 2, QueueSize: 100, Timeout: time.Hour, Retention: time.Hour},
+		},
+		Client: clientConfig{
+			Timeout:          time.Minute,
+			InvalidInstances: rejectPolicy,
+		},
+	}
+}
+
+// configEnvName converts a flag name to the name of the environment variable overriding it: max-cache -> LRS_MAX_CACHE.
+func configEnvName(flagName string) string {
+	return configEnvPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+func loadConfigFile(path string, config *serviceConfig) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %v", err)
+	}
+	// JSON is a subset of YAML, so both formats are loaded the same way.
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return fmt.Errorf("cannot parse config file %v: %v", path, err)
+	}
+	return nil
+}
+
+// parseConfig fills the configuration from, in the order of increasing priority: the defaults,
+// the --config file, the LRS_* environment variables and the command line flags.
+// The config flags must be bound to the fields of the given config before the call.
+func parseConfig(config *serviceConfig, configFlags []string) error {
+	configPath := flag.String("config", os.Getenv(configEnvPrefix + "CONFIG"), "YAML or JSON configuration file")
+	flag.Parse()
+
+	explicitFlags := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		explicitFlags[f.Name] = f.Value.String()
+	})
+
+	if len(*configPath) > 0 {
+		if err := loadConfigFile(*configPath, config); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range configFlags {
+		value, ok := os.LookupEnv(configEnvName(name))
+		if !ok {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value of %v: %v", configEnvName(name), err)
+		}
+	}
+
+	for _, name := range configFlags {
+		if value, ok := explicitFlags[name]; ok {
+			if err := flag.Set(name, value); err != nil {
+				return fmt.Errorf("invalid value of --%v: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
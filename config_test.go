@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// loadTestHandlerConfig loads the http handler's config from the given command line and the environment,
+// using a fresh flag set instead of the process's one.
+func loadTestHandlerConfig(t *testing.T, args ...string) (*handlerConfig, error) {
+	t.Helper()
+	commandLine, osArgs := flag.CommandLine, os.Args
+	defer func() {
+		flag.CommandLine, os.Args = commandLine, osArgs
+	}()
+	flag.CommandLine = flag.NewFlagSet("linear_regression_service", flag.ContinueOnError)
+	flag.CommandLine.SetOutput(ioutil.Discard)
+	os.Args = append([]string{"linear_regression_service"}, args...)
+	return loadHandlerConfig(httpMode)
+}
+
+func TestLoadHandlerConfigPrecedence(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	configData := `
+handler:
+  storage:
+    spanner_project: file-project
+    spanner_instance: file-instance
+    spanner_database: file-database
+    max_cache: 10
+  max_instances: 1000
+  port: "9000"
+  jobs:
+    workers: 4
+    retention: 2h
+`
+	if err := ioutil.WriteFile(configFile, []byte(configData), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("LRS_MAX_CACHE", "20")
+	t.Setenv("LRS_PORT", "9001")
+	config, err := loadTestHandlerConfig(t, "--config", configFile, "--port", "9002", "--calc-rate", "5")
+	if err != nil {
+		t.Fatalf("loadHandlerConfig() error: %v", err)
+	}
+
+	if config.Storage.Project != "file-project" || config.MaxInstances != 1000 || config.Jobs.Workers != 4 || config.Jobs.Retention != 2 * time.Hour {
+		t.Errorf("config file values are not loaded: %+v", config)
+	}
+	if config.Storage.MaxCache != 20 {
+		t.Errorf("max cache %v, want the environment's 20 over the file's 10", config.Storage.MaxCache)
+	}
+	if config.Port != "9002" {
+		t.Errorf("port %v, want the flag's 9002 over the environment's 9001 and the file's 9000", config.Port)
+	}
+	if config.Limits.CalcRate != 5 {
+		t.Errorf("calc rate %v, want the flag's 5", config.Limits.CalcRate)
+	}
+	if config.Jobs.QueueSize != 100 || config.MaxRequestBytes != 64 << 20 {
+		t.Errorf("defaults are not kept for the unset values: %+v", config)
+	}
+}
+
+func TestLoadHandlerConfigFromEnvironment(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	configData := `{"handler": {"storage": {"spanner_project": "p", "spanner_instance": "i", "spanner_database": "d"}}}`
+	if err := ioutil.WriteFile(configFile, []byte(configData), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("LRS_CONFIG", configFile)
+	t.Setenv("LRS_INVALID_INSTANCES", "skip")
+	config, err := loadTestHandlerConfig(t)
+	if err != nil {
+		t.Fatalf("loadHandlerConfig() error: %v", err)
+	}
+	if config.Storage.Database != "d" {
+		t.Errorf("database %q, want the one of the LRS_CONFIG file", config.Storage.Database)
+	}
+	if config.InvalidInstances != skipPolicy {
+		t.Errorf("invalid instances policy %v, want %v", config.InvalidInstances, skipPolicy)
+	}
+}
+
+func TestLoadHandlerConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	unknownField := filepath.Join(dir, "unknown.yaml")
+	if err := ioutil.WriteFile(unknownField, []byte("handler:\n  ports: 8080\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	spanner := []string{"--spanner-project", "p", "--spanner-instance", "i", "--spanner-database", "d"}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+	}{
+		{"missing spanner project", nil, []string{"--spanner-instance", "i", "--spanner-database", "d"}},
+		{"missing config file", nil, append([]string{"--config", filepath.Join(dir, "missing.yaml")}, spanner...)},
+		{"unknown config field", nil, append([]string{"--config", unknownField}, spanner...)},
+		{"invalid environment value", map[string]string{"LRS_MAX_CACHE": "many"}, spanner},
+		{"invalid policy", map[string]string{"LRS_INVALID_INSTANCES": "ignore"}, spanner},
+		{"non-positive cache", nil, append([]string{"--max-cache", "0"}, spanner...)},
+		{"too large requests", nil, append([]string{"--max-request-bytes", "4294967296"}, spanner...)},
+		{"no job workers", nil, append([]string{"--job-workers", "0"}, spanner...)},
+		{"TLS key without certificate", nil, append([]string{"--tls-key", "server.key"}, spanner...)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			if _, err := loadTestHandlerConfig(t, test.args...); err == nil {
+				t.Errorf("loadHandlerConfig(%v) succeeded, want an error", test.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MethodStats stores all-time execution statistics for a single API method served via a single protocol.
+type MethodStats struct {
+	// Protocol stores the name of the protocol the method was called with: http or grpc.
+	Protocol string
+
+	// Method stores the name of the called method: train, calc, stats, get_model or delete_model.
+	Method string
+
+	// SucceededRequests stores the number of successfully processed requests.
+	SucceededRequests int
+
+	// TotalRequests stores the total number of received requests.
+	TotalRequests int
+
+	// TotalInstances stores the total number of instances used while learning models.
+	TotalInstances int
+}
+
+// TenantStats stores all-time execution statistics for the requests of a single tenant.
+type TenantStats struct {
+	// Tenant stores the name of the tenant.
+	Tenant string
+
+	// SucceededRequests stores the number of successfully processed requests.
+	SucceededRequests int
+
+	// TotalRequests stores the total number of received requests.
+	TotalRequests int
+
+	// TotalInstances stores the total number of instances used while learning models.
+	TotalInstances int
+}
+
+// ExecutionStats stores all-time execution statistics for the service.
+type ExecutionStats struct {
+	// SucceededRequests stores the number of successfully processed requests.
+	SucceededRequests int
+
+	// TotalRequests stores the total number of received requests.
+	TotalRequests int
+
+	// TotalInstances stores the total number of instances used while learning models.
+	TotalInstances int
+
+	// CacheHits stores the number of models taken from the local cache.
+	CacheHits int
+
+	// CacheMisses stores the number of models not found in the local cache.
+	CacheMisses int
+
+	// StorageReads stores the number of models loaded from Spanner database.
+	StorageReads int
+
+	// StorageWrites stores the number of models saved to Spanner database.
+	StorageWrites int
+
+	// Methods stores the per-method and per-protocol execution statistics.
+	Methods []MethodStats
+
+	// Tenants stores the per-tenant execution statistics.
+	Tenants []TenantStats
+
+	// Windows stores the execution statistics for the last minute, five minutes and hour.
+	Windows []WindowStats
+}
+
+type methodKey struct {
+	protocol  protocolMode
+	operation operationMode
+}
+
+// requestStats accumulates the information about a single request while it is being processed.
+type requestStats struct {
+	key       methodKey
+	started   time.Time
+	finished  time.Time
+	Succeeded bool
+	Instances int
+
+	// Tenant stays empty until the request's tenant is resolved.
+	Tenant string
+}
+
+type statsUpdate struct {
+	request *requestStats
+
+	cacheHits     int
+	cacheMisses   int
+	storageReads  int
+	storageWrites int
+}
+
+// statsCollector gathers the execution statistics shared by all the handlers and the models storage.
+type statsCollector struct {
+	stats   ExecutionStats
+	total   MethodStats
+	methods map[methodKey]*MethodStats
+	tenants map[string]*TenantStats
+	windows *windowedStats
+	updates chan statsUpdate
+	metrics *serviceMetrics
+
+	stopping chan struct{}
+	stopped  chan struct{}
+
+	mutex sync.Mutex
+}
+
+func newStatsCollector() *statsCollector {
+	sc := statsCollector{
+		methods: map[methodKey]*MethodStats{},
+		tenants: map[string]*TenantStats{},
+		windows: newWindowedStats(),
+		updates: make(chan statsUpdate),
+		metrics: newServiceMetrics(),
+
+		stopping: make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go sc.updateStatsLoop()
+	return &sc
+}
+
+func (sc *statsCollector) updateStatsLoop() {
+	defer close(sc.stopped)
+	for {
+		select {
+		case u := <-sc.updates:
+			sc.apply(u)
+		case <-sc.stopping:
+			return
+		}
+	}
+}
+
+// send passes the update to the stats loop; updates arriving after the loop is stopped are dropped.
+func (sc *statsCollector) send(u statsUpdate) {
+	select {
+	case sc.updates <- u:
+	case <-sc.stopped:
+	}
+}
+
+// flush stops the stats loop and returns the final statistics.
+func (sc *statsCollector) flush() ExecutionStats {
+	close(sc.stopping)
+	<-sc.stopped
+	return sc.getStats()
+}
+
+func (sc *statsCollector) apply(u statsUpdate) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.stats.CacheHits += u.cacheHits
+	sc.stats.CacheMisses += u.cacheMisses
+	sc.stats.StorageReads += u.storageReads
+	sc.stats.StorageWrites += u.storageWrites
+
+	if u.request == nil {
+		return
+	}
+
+	methodStats, ok := sc.methods[u.request.key]
+	if !ok {
+		methodStats = &MethodStats{
+			Protocol: protocolPrefix(u.request.key.protocol),
+			Method:   operationName(u.request.key.operation),
+		}
+		sc.methods[u.request.key] = methodStats
+	}
+	methodStats.add(u.request)
+	sc.total.add(u.request)
+
+	if len(u.request.Tenant) > 0 {
+		tenantStats, ok := sc.tenants[u.request.Tenant]
+		if !ok {
+			tenantStats = &TenantStats{Tenant: u.request.Tenant}
+			sc.tenants[u.request.Tenant] = tenantStats
+		}
+		tenantStats.add(u.request)
+	}
+	sc.windows.add(u.request.finished, u.request.Succeeded, u.request.finished.Sub(u.request.started))
+}
+
+func (ms *MethodStats) add(request *requestStats) {
+	ms.TotalRequests++
+	ms.TotalInstances += request.Instances
+	if request.Succeeded {
+		ms.SucceededRequests++
+	}
+}
+
+func (ts *TenantStats) add(request *requestStats) {
+	ts.TotalRequests++
+	ts.TotalInstances += request.Instances
+	if request.Succeeded {
+		ts.SucceededRequests++
+	}
+}
+
+// getStats returns a snapshot of the collected statistics.
+func (sc *statsCollector) getStats() ExecutionStats {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	stats := sc.stats
+	stats.SucceededRequests = sc.total.SucceededRequests
+	stats.TotalRequests = sc.total.TotalRequests
+	stats.TotalInstances = sc.total.TotalInstances
+	stats.Methods = make([]MethodStats, 0, len(sc.methods))
+	for _, s := range sc.methods {
+		stats.Methods = append(stats.Methods, *s)
+	}
+	sort.Slice(stats.Methods, func(i, j int) bool {
+		if stats.Methods[i].Protocol != stats.Methods[j].Protocol {
+			return stats.Methods[i].Protocol < stats.Methods[j].Protocol
+		}
+		return stats.Methods[i].Method < stats.Methods[j].Method
+	})
+	stats.Tenants = make([]TenantStats, 0, len(sc.tenants))
+	for _, s := range sc.tenants {
+		stats.Tenants = append(stats.Tenants, *s)
+	}
+	sort.Slice(stats.Tenants, func(i, j int) bool {
+		return stats.Tenants[i].Tenant < stats.Tenants[j].Tenant
+	})
+	stats.Windows = sc.windows.get(time.Now())
+	return stats
+}
+
+// getTenantStats returns a snapshot of the collected statistics with the per-tenant ones limited to the given tenant.
+// All the tenants are kept if the tenant is empty.
+func (sc *statsCollector) getTenantStats(tenant string) ExecutionStats {
+	stats := sc.getStats()
+	if len(tenant) == 0 {
+		return stats
+	}
+
+	var tenants []TenantStats
+	for _, s := range stats.Tenants {
+		if s.Tenant == tenant {
+			tenants = append(tenants, s)
+		}
+	}
+	stats.Tenants = tenants
+	return stats
+}
+
+// startRequest creates a request record; pass it to finishRequest once the request is processed.
+func (sc *statsCollector) startRequest(protocol protocolMode, operation operationMode) *requestStats {
+	return &requestStats{key: methodKey{protocol: protocol, operation: operation}, started: time.Now()}
+}
+
+func (sc *statsCollector) finishRequest(request *requestStats) {
+	request.finished = time.Now()
+	sc.metrics.observeRequest(request, request.finished.Sub(request.started))
+	sc.send(statsUpdate{request: request})
+}
+
+func (sc *statsCollector) reportCacheLookup(hit bool) {
+	sc.metrics.observeCacheLookup(hit)
+	if hit {
+		sc.send(statsUpdate{cacheHits: 1})
+	} else {
+		sc.send(statsUpdate{cacheMisses: 1})
+	}
+}
+
+func (sc *statsCollector) reportCacheEviction() {
+	sc.metrics.cacheEvictions.Inc()
+}
+
+func (sc *statsCollector) reportStorageRead(started time.Time, err error) {
+	sc.metrics.observeStorageCall("read", time.Since(started), err)
+	if err == nil {
+		sc.send(statsUpdate{storageReads: 1})
+	}
+}
+
+func (sc *statsCollector) reportStorageWrite(started time.Time, err error) {
+	sc.metrics.observeStorageCall("write", time.Since(started), err)
+	if err == nil {
+		sc.send(statsUpdate{storageWrites: 1})
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"strconv"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"github.com/golang/protobuf/jsonpb"
 
 	pb "linear_regression_service/github.com/ashagraev/linear_regression"
@@ -47,26 +48,39 @@ func reportProtoJSON(m proto.Message) (string, error) {
 	return prettyJson.String(), nil
 }
 
-func createConnection(serverPath string) (*grpc.ClientConn, error) {
+func (rc *regressionClient) createConnection() (*grpc.ClientConn, error) {
 	opts := []grpc.DialOption{
 		grpc.WithInsecure(),
 	}
+	if rc.tlsConfig != nil {
+		opts = []grpc.DialOption{
+			grpc.WithTransportCredentials(credentials.NewTLS(rc.tlsConfig)),
+		}
+	}
+	if len(rc.apiKey) > 0 {
+		opts = append(opts, grpc.WithPerRPCCredentials(apiKeyCredentials{apiKey: rc.apiKey, requireTLS: rc.tlsConfig != nil}))
+	}
 
-	return grpc.Dial(serverPath, opts...)
+	return grpc.Dial(rc.serverPath, opts...)
 }
 
 func (rc *regressionClient) requestGRPCTraining(ctx context.Context, instances []*pb.Instance) (string, error) {
-	conn, err := createConnection(rc.serverPath)
+	conn, err := rc.createConnection()
 	if err != nil {
 		return "", fmt.Errorf("cannot create grpc dial: %v", err)
 	}
 	defer conn.Close()
 
+	ctx, cancel := rc.requestContext(ctx)
+	defer cancel()
+
 	client := pb.NewRegressionClient(conn)
 
 	result, err := client.Train(ctx, &pb.TrainingRequest{
 		Instances:	instances,
 		StoreModel:	true,
+		Tenant:		rc.tenant,
+		IdempotencyKey:	rc.idempotencyKey,
 	})
 	if err != nil {
 		return "", fmt.Errorf("error processing training request: %v", err)
@@ -76,17 +90,21 @@ func (rc *regressionClient) requestGRPCTraining(ctx context.Context, instances [
 }
 
 func (rc *regressionClient) requestGRPCCalculation(ctx context.Context, arg float64) (string, error) {
-	conn, err := createConnection(rc.serverPath)
+	conn, err := rc.createConnection()
 	if err != nil {
 		return "", fmt.Errorf("cannot create grpc dial: %v", err)
 	}
 	defer conn.Close()
 
+	ctx, cancel := rc.requestContext(ctx)
+	defer cancel()
+
 	client := pb.NewRegressionClient(conn)
 
 	modelValue, err := client.Calculate(ctx, &pb.CalculateRequest{
 		Argument: arg,
 		ModelName: rc.modelName,
+		Tenant: rc.tenant,
 	})
 	if err != nil {
 		return "", fmt.Errorf("error processing calculation request: %v", err)
@@ -96,12 +114,15 @@ func (rc *regressionClient) requestGRPCCalculation(ctx context.Context, arg floa
 }
 
 func (rc *regressionClient) requestGRPCStats(ctx context.Context) (string, error) {
-	conn, err := createConnection(rc.serverPath)
+	conn, err := rc.createConnection()
 	if err != nil {
 		return "", fmt.Errorf("cannot create grpc dial: %v", err)
 	}
 	defer conn.Close()
 
+	ctx, cancel := rc.requestContext(ctx)
+	defer cancel()
+
 	client := pb.NewRegressionClient(conn)
 	stats, err := client.Stats(ctx, &pb.StatsRequest{})
 	if err != nil {
@@ -114,7 +135,7 @@ func (rc *regressionClient) requestGRPCStats(ctx context.Context) (string, error
 func runGRPCTraining() {
 	client := newTrainingGRPCClient()
 
-	instances, err := loadProtoInstancesFromTSV(os.Stdin)
+	instances, err := client.loadTrainingInstances()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func newDescribingGRPCClient() *regressionClient {
+	return newRegressionClient(describeMode, grpcMode)
+}
+
+// reflectionRequest sends a single request over the reflection stream and waits for its response.
+func reflectionRequest(stream rpb.ServerReflection_ServerReflectionInfoClient, request *rpb.ServerReflectionRequest) (*rpb.ServerReflectionResponse, error) {
+	if err := stream.Send(request); err != nil {
+		return nil, fmt.Errorf("error sending reflection request: %v", err)
+	}
+	response, err := stream.Recv()
+	if err != nil {
+		return nil, fmt.Errorf("error receiving reflection response: %v", err)
+	}
+	if errorResponse := response.GetErrorResponse(); errorResponse != nil {
+		return nil, fmt.Errorf("reflection error: %v", errorResponse.ErrorMessage)
+	}
+	return response, nil
+}
+
+// fieldTypeName formats the field's type the way .proto files do.
+func fieldTypeName(field protoreflect.FieldDescriptor) string {
+	if field.IsMap() {
+		return fmt.Sprintf("map<%v, %v>", fieldTypeName(field.MapKey()), fieldTypeName(field.MapValue()))
+	}
+	typeName := field.Kind().String()
+	switch field.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		typeName = string(field.Message().FullName())
+	case protoreflect.EnumKind:
+		typeName = string(field.Enum().FullName())
+	}
+	if field.IsList() {
+		return "repeated " + typeName
+	}
+	return typeName
+}
+
+// schemaDescriber formats the services and the messages they use, each message once.
+type schemaDescriber struct {
+	description strings.Builder
+	described   map[protoreflect.FullName]bool
+}
+
+func (sd *schemaDescriber) describeService(service protoreflect.ServiceDescriptor) {
+	var messages []protoreflect.MessageDescriptor
+
+	fmt.Fprintf(&sd.description, "service %v {\n", service.FullName())
+	methods := service.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		method := methods.Get(i)
+		input, output := string(method.Input().FullName()), string(method.Output().FullName())
+		if method.IsStreamingClient() {
+			input = "stream " + input
+		}
+		if method.IsStreamingServer() {
+			output = "stream " + output
+		}
+		fmt.Fprintf(&sd.description, "  rpc %v(%v) returns (%v);", method.Name(), input, output)
+		if rule, ok := proto.GetExtension(method.Options(), annotations.E_Http).(*annotations.HttpRule); ok && rule != nil {
+			httpMethod, path := httpRulePattern(rule)
+			fmt.Fprintf(&sd.description, " // %v %v", httpMethod, path)
+		}
+		sd.description.WriteString("\n")
+		messages = append(messages, method.Input(), method.Output())
+	}
+	sd.description.WriteString("}\n")
+
+	for _, message := range messages {
+		sd.describeMessage(message)
+	}
+}
+
+func (sd *schemaDescriber) describeMessage(message protoreflect.MessageDescriptor) {
+	if sd.described[message.FullName()] {
+		return
+	}
+	sd.described[message.FullName()] = true
+
+	var nested []protoreflect.FieldDescriptor
+	fmt.Fprintf(&sd.description, "\nmessage %v {\n", message.FullName())
+	fields := message.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		fmt.Fprintf(&sd.description, "  %v %v = %v;\n", fieldTypeName(field), field.Name(), field.Number())
+		if field.IsMap() {
+			field = field.MapValue()
+		}
+		if field.Message() != nil || field.Enum() != nil {
+			nested = append(nested, field)
+		}
+	}
+	sd.description.WriteString("}\n")
+
+	for _, field := range nested {
+		if field.Enum() != nil {
+			sd.describeEnum(field.Enum())
+		} else {
+			sd.describeMessage(field.Message())
+		}
+	}
+}
+
+func (sd *schemaDescriber) describeEnum(enum protoreflect.EnumDescriptor) {
+	if sd.described[enum.FullName()] {
+		return
+	}
+	sd.described[enum.FullName()] = true
+
+	fmt.Fprintf(&sd.description, "\nenum %v {\n", enum.FullName())
+	values := enum.Values()
+	for i := 0; i < values.Len(); i++ {
+		fmt.Fprintf(&sd.description, "  %v = %v;\n", values.Get(i).Name(), values.Get(i).Number())
+	}
+	sd.description.WriteString("}\n")
+}
+
+// requestGRPCDescription lists the server's services via gRPC reflection and describes their methods and messages.
+func (rc *regressionClient) requestGRPCDescription(ctx context.Context) (string, error) {
+	conn, err := rc.createConnection()
+	if err != nil {
+		return "", fmt.Errorf("cannot create grpc dial: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := rc.requestContext(ctx)
+	defer cancel()
+
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error opening reflection stream: %v", err)
+	}
+	defer stream.CloseSend()
+
+	response, err := reflectionRequest(stream, &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// The server sends each file with all its dependencies not sent over the stream before.
+	var services []string
+	var files descriptorpb.FileDescriptorSet
+	for _, service := range response.GetListServicesResponse().GetService() {
+		// The reflection service itself is of no interest.
+		if strings.HasPrefix(service.Name, "grpc.reflection.") {
+			continue
+		}
+		services = append(services, service.Name)
+		response, err := reflectionRequest(stream, &rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: service.Name},
+		})
+		if err != nil {
+			return "", err
+		}
+		for _, data := range response.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			file := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(data, file); err != nil {
+				return "", fmt.Errorf("error decoding file descriptor: %v", err)
+			}
+			files.File = append(files.File, file)
+		}
+	}
+
+	registry, err := protodesc.NewFiles(&files)
+	if err != nil {
+		return "", fmt.Errorf("error resolving file descriptors: %v", err)
+	}
+
+	describer := schemaDescriber{described: map[protoreflect.FullName]bool{}}
+	for idx, name := range services {
+		descriptor, err := registry.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			return "", fmt.Errorf("error resolving service %v: %v", name, err)
+		}
+		if idx > 0 {
+			describer.description.WriteString("\n")
+		}
+		describer.describeService(descriptor.(protoreflect.ServiceDescriptor))
+	}
+	return describer.description.String(), nil
+}
+
+func runGRPCDescription() {
+	client := newDescribingGRPCClient()
+	ctx := context.Background()
+
+	result, err := client.requestGRPCDescription(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Print(result)
+}
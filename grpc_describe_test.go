@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	pb "linear_regression_service/github.com/ashagraev/linear_regression"
+)
+
+// startTestGRPCServer serves the regression, health and reflection services as runGRPCHandler does,
+// with the given authentication settings and without the models storage.
+func startTestGRPCServer(t *testing.T, auth authConfig) string {
+	t.Helper()
+	config := defaultServiceConfig().Handler
+	config.Auth = auth
+	h := &grpcHandler{config: &config, stats: newStatsCollector(), limiter: newClientLimiter(&config.Limits), protocol: grpcMode}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(h.authUnaryInterceptor, h.requestTimeoutInterceptor),
+		grpc.ChainStreamInterceptor(h.authStreamInterceptor),
+	)
+	pb.RegisterRegressionService(grpcServer, h.Svc())
+	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+	reflection.Register(grpcServer)
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+	return lis.Addr().String()
+}
+
+func TestRequestGRPCDescription(t *testing.T) {
+	// The reflection is served without the API keys even when they are required by the regression service.
+	address := startTestGRPCServer(t, testAuthConfig)
+	client := &regressionClient{serverPath: address, timeout: 5 * time.Second}
+
+	description, err := client.requestGRPCDescription(context.Background())
+	if err != nil {
+		t.Fatalf("requestGRPCDescription() error: %v", err)
+	}
+
+	for _, want := range []string{
+		"service linear_regression.Regression {\n",
+		"  rpc Train(linear_regression.TrainingRequest) returns (linear_regression.TrainingResults); // POST /v1/models:train\n",
+		"  rpc GetJob(linear_regression.GetJobRequest) returns (linear_regression.TrainingJob); // GET /v1/jobs/{job_id}\n",
+		"\nmessage linear_regression.TrainingRequest {\n",
+		"  repeated linear_regression.Instance instances = ",
+		"\nenum linear_regression.TrainingJob.State {\n",
+		"service grpc.health.v1.Health {\n",
+		"  rpc Watch(grpc.health.v1.HealthCheckRequest) returns (stream grpc.health.v1.HealthCheckResponse);\n",
+	} {
+		if !strings.Contains(description, want) {
+			t.Errorf("description has no %q:\n%v", want, description)
+		}
+	}
+	if strings.Contains(description, "grpc.reflection.") {
+		t.Errorf("description lists the reflection service:\n%v", description)
+	}
+	if count := strings.Count(description, "\nmessage linear_regression.TrainingResults {"); count != 1 {
+		t.Errorf("TrainingResults is described %v times, want once", count)
+	}
+}
+
+func TestRequestGRPCDescriptionUnavailable(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	address := lis.Addr().String()
+	lis.Close()
+
+	client := &regressionClient{serverPath: address, timeout: time.Second}
+	if _, err := client.requestGRPCDescription(context.Background()); err == nil {
+		t.Errorf("requestGRPCDescription() of a stopped server succeeded, want an error")
+	}
+}
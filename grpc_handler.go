@@ -2,146 +2,421 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 	pb "linear_regression_service/github.com/ashagraev/linear_regression"
 	"log"
 	"net"
-	"sync"
 	"time"
 )
 
 type grpcHandler struct {
-	stats        pb.ServerStats
-	requestStats chan pb.ServerStats
-
-	statsMutex sync.Mutex
+	config  *handlerConfig
+	stats   *statsCollector
+	limiter *clientLimiter
 
 	modelsStorage *modelsStorage
+	jobs          *jobsManager
+
+	// protocol stores the protocol the requests are counted with: grpc, or http if the calls are transcoded.
+	protocol protocolMode
 }
 
-func newGRPCHandler(ctx context.Context) (*grpcHandler, error) {
-	modelsStorage, err := newModelsStorage(ctx)
+func newGRPCHandler(ctx context.Context, config *handlerConfig) (*grpcHandler, error) {
+	stats := newStatsCollector()
+	modelsStorage, err := newModelsStorage(ctx, config.Storage, stats)
 	if err != nil {
 		return nil, err
 	}
-	h := grpcHandler{requestStats: make(chan pb.ServerStats), modelsStorage: modelsStorage}
-	go h.updateStatsLoop()
-	return &h, nil
-}
-
-func (h* grpcHandler) getStats() pb.ServerStats {
-	h.statsMutex.Lock()
-	defer h.statsMutex.Unlock()
-
-	return h.stats
+	limiter := newClientLimiter(&config.Limits)
+	return &grpcHandler{
+		config:        config,
+		stats:         stats,
+		limiter:       limiter,
+		modelsStorage: modelsStorage,
+		jobs:          newJobsManager(config, modelsStorage),
+		protocol:      grpcMode,
+	}, nil
 }
 
-func (h* grpcHandler) setStats(stats pb.ServerStats) {
-	h.statsMutex.Lock()
-	defer h.statsMutex.Unlock()
-
-	h.stats = stats
+// requestTimeoutInterceptor limits the processing time of the unary calls.
+func (h *grpcHandler) requestTimeoutInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, cancel := withRequestTimeout(ctx, h.config)
+	defer cancel()
+	return handler(ctx, req)
 }
 
 func (h *grpcHandler) Svc() *pb.RegressionService {
 	return &pb.RegressionService{
 		Train: h.Train,
 		Calculate: h.Calculate,
+		GetModel: h.GetModel,
+		DeleteModel: h.DeleteModel,
+		SubmitTrainingJob: h.SubmitTrainingJob,
+		GetJob: h.GetJob,
+		CancelJob: h.CancelJob,
 		Stats: h.Stats,
 	}
 }
 
-func (h *grpcHandler) updateStatsLoop() {
-	for r := range h.requestStats {
-		stats := h.getStats()
-		stats.TotalRequests += r.TotalRequests
-		stats.TotalInstances += r.TotalInstances
-		stats.SucceededRequests += r.SucceededRequests
-		h.setStats(stats)
+// resolveGRPCTenant chooses the tenant by the request's tenant field and converts the errors to gRPC statuses.
+func resolveGRPCTenant(ctx context.Context, requested string, requestInfo *requestStats) (string, error) {
+	tenant, err := resolveTenant(ctx, requested)
+	if err == errTenantForbidden {
+		return "", status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
 	}
+	requestInfo.Tenant = tenant
+	return tenant, nil
 }
 
-func (h *grpcHandler) Train(ctx context.Context, request *pb.TrainingRequest) (*pb.TrainingResults, error) {
-	var slr SimpleLinearRegression
-	for _, instance := range request.Instances {
-		slr.AddWeightedInstance(instance.Argument, instance.Target, instance.Weight)
+// modelStatusError converts the model loading errors to gRPC statuses.
+func modelStatusError(modelName string, err error) error {
+	if err == errModelNotFound {
+		return status.Errorf(codes.NotFound, "model %v is not found", modelName)
 	}
+	return status.Errorf(codes.Internal, "error loading model %v: %v", modelName, err)
+}
 
-	model := slr.Train()
-	result := pb.TrainingResults{
-		Model: &pb.SimpleRegressionModel{
-			Coefficient: model.Coefficient,
-			Intercept: model.Intercept,
-		},
-		SumSquaredErrors: slr.SumSquaredErrors(),
-	}
+func (h *grpcHandler) Train(ctx context.Context, request *pb.TrainingRequest) (*pb.TrainingResults, error) {
+	requestInfo := h.stats.startRequest(h.protocol, trainMode)
+	defer h.stats.finishRequest(requestInfo)
+	requestInfo.Instances = len(request.Instances)
 
-	if request.StoreModel {
-		name, commitTime, err := h.modelsStorage.saveSLRModel(ctx, model)
+	tenant, err := resolveGRPCTenant(ctx, request.Tenant, requestInfo)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkInstancesLimit(h.config, len(request.Instances)); err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err := checkIdempotencyKey(request.IdempotencyKey); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// The idempotency key is ignored if the model is not stored.
+	// The replayed requests are trained again to check their data, but are not counted against the quota.
+	var storedResult *TrainingResults
+	if request.StoreModel && len(request.IdempotencyKey) > 0 {
+		if storedResult, err = h.modelsStorage.findIdempotentResults(ctx, tenant, request.IdempotencyKey); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	var reservation *quotaReservation
+	if request.StoreModel && storedResult == nil {
+		reservation, err = h.limiter.reserveStoredModel(grpcClientID(ctx))
 		if err != nil {
-			result.Error = fmt.Sprintf("%v", err)
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
 		}
-		result.Name = name
-		result.CreationTime = fmt.Sprintf("%v", commitTime)
+		defer reservation.release()
+	}
+
+	result, err := trainInstances(grpcTrainingSource(request.Instances), newInstanceValidator(h.config.InvalidInstances))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	return &result, nil
+	if storedResult != nil {
+		result, err = replayIdempotentResults(storedResult, result)
+	} else if request.StoreModel {
+		result, _, err = storeTrainingResults(ctx, h.modelsStorage, reservation, tenant, request.IdempotencyKey, result)
+	}
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	requestInfo.Succeeded = len(result.Error) == 0
+
+	return result.toProto().(*pb.TrainingResults), nil
+}
+
+// grpcTrainingSource passes the request's instances to consume with their weights.
+func grpcTrainingSource(instances []*pb.Instance) trainingSource {
+	return func(consume func(position int, instance []float64) error) (int, error) {
+		for idx, instance := range instances {
+			if err := consume(idx, []float64{instance.Argument, instance.Target, instance.Weight}); err != nil {
+				return idx, err
+			}
+		}
+		return len(instances), nil
+	}
 }
 
 func (h *grpcHandler) Calculate(ctx context.Context, request *pb.CalculateRequest) (*pb.ModelValue, error) {
-	requestInfo := pb.ServerStats{
-		TotalRequests: 1,
+	requestInfo := h.stats.startRequest(h.protocol, calculateMode)
+	defer h.stats.finishRequest(requestInfo)
+
+	tenant, err := resolveGRPCTenant(ctx, request.Tenant, requestInfo)
+	if err != nil {
+		return nil, err
 	}
-	defer func() {
-		h.requestStats <- requestInfo
-	}()
+	if err := h.limiter.allowCalculation(grpcClientID(ctx)); err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	model, fromCache, err := h.modelsStorage.getSLRModel(ctx, tenant, request.ModelName)
+	if err != nil {
+		return &pb.ModelValue{Error: fmt.Sprintf("error loading model %v: %v", request.ModelName, err)}, modelStatusError(request.ModelName, err)
+	}
+
+	modelValue := ModelValue{
+		Value:           model.Calculate(request.Argument),
+		Argument:        request.Argument,
+		Model:           model,
+		CalculationTime: time.Now().UTC(),
+		FromCache:       fromCache,
+	}
+	requestInfo.Succeeded = true
+
+	return modelValue.toProto().(*pb.ModelValue), nil
+}
+
+func (h *grpcHandler) GetModel(ctx context.Context, request *pb.GetModelRequest) (*pb.SimpleRegressionModel, error) {
+	requestInfo := h.stats.startRequest(h.protocol, getModelMode)
+	defer h.stats.finishRequest(requestInfo)
+
+	tenant, err := resolveGRPCTenant(ctx, request.Tenant, requestInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	model, _, err := h.modelsStorage.getSLRModel(ctx, tenant, request.ModelName)
+	if err != nil {
+		return nil, modelStatusError(request.ModelName, err)
+	}
+	requestInfo.Succeeded = true
+
+	return model.toProtoModel(), nil
+}
+
+func (h *grpcHandler) DeleteModel(ctx context.Context, request *pb.DeleteModelRequest) (*emptypb.Empty, error) {
+	requestInfo := h.stats.startRequest(h.protocol, deleteModelMode)
+	defer h.stats.finishRequest(requestInfo)
+
+	tenant, err := resolveGRPCTenant(ctx, request.Tenant, requestInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.modelsStorage.deleteSLRModel(ctx, tenant, request.ModelName)
+	if err == errModelNotFound {
+		return nil, modelStatusError(request.ModelName, err)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error deleting model %v: %v", request.ModelName, err)
+	}
+	requestInfo.Succeeded = true
+
+	return &emptypb.Empty{}, nil
+}
+
+// jobStatusError converts the jobs manager's errors to gRPC statuses.
+func jobStatusError(jobID string, err error) error {
+	var quotaErr *quotaError
+	switch {
+	case err == errJobNotFound:
+		return status.Errorf(codes.NotFound, "job %v is not found", jobID)
+	case errors.As(err, &quotaErr):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case err == errJobsStopped:
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func (h *grpcHandler) SubmitTrainingJob(ctx context.Context, request *pb.TrainingRequest) (*pb.TrainingJob, error) {
+	requestInfo := h.stats.startRequest(h.protocol, submitJobMode)
+	defer h.stats.finishRequest(requestInfo)
+	requestInfo.Instances = len(request.Instances)
+
+	tenant, err := resolveGRPCTenant(ctx, request.Tenant, requestInfo)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkInstancesLimit(h.config, len(request.Instances)); err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err := checkIdempotencyKey(request.IdempotencyKey); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	reservation, err := h.limiter.reserveStoredModel(grpcClientID(ctx))
+	if err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	// The jobs always store the trained models, so the request's store_model is ignored.
+	job, err := h.jobs.submit(tenant, reservation, request.IdempotencyKey, len(request.Instances), grpcTrainingSource(request.Instances), func() {})
+	if err != nil {
+		reservation.release()
+		return nil, jobStatusError("", err)
+	}
+	requestInfo.Succeeded = true
+
+	return job.toProto().(*pb.TrainingJob), nil
+}
+
+func (h *grpcHandler) GetJob(ctx context.Context, request *pb.GetJobRequest) (*pb.TrainingJob, error) {
+	requestInfo := h.stats.startRequest(h.protocol, getJobMode)
+	defer h.stats.finishRequest(requestInfo)
+
+	tenant, err := resolveGRPCTenant(ctx, request.Tenant, requestInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := h.jobs.get(tenant, request.JobId)
+	if err != nil {
+		return nil, jobStatusError(request.JobId, err)
+	}
+	requestInfo.Succeeded = true
+
+	return job.toProto().(*pb.TrainingJob), nil
+}
 
-	modelValue := pb.ModelValue{}
+func (h *grpcHandler) CancelJob(ctx context.Context, request *pb.CancelJobRequest) (*pb.TrainingJob, error) {
+	requestInfo := h.stats.startRequest(h.protocol, cancelJobMode)
+	defer h.stats.finishRequest(requestInfo)
 
-	model, fromCache, err := h.modelsStorage.getSLRModel(ctx, request.ModelName)
+	tenant, err := resolveGRPCTenant(ctx, request.Tenant, requestInfo)
 	if err != nil {
-		modelValue.Error = fmt.Sprintf("error loading model %v: %v", request.ModelName, err)
-		return &modelValue, err
+		return nil, err
 	}
 
-	modelValue.Argument = request.Argument
-	modelValue.FromCache = fromCache
-	modelValue.Value = model.Calculate(request.Argument)
-	modelValue.CalculationTime = fmt.Sprintf("%v", time.Now())
-	modelValue.Model = &pb.SimpleRegressionModel{
-		Name:        model.Name,
-		Intercept:   model.Intercept,
-		Coefficient: model.Coefficient,
+	job, err := h.jobs.cancel(tenant, request.JobId)
+	if err != nil {
+		return nil, jobStatusError(request.JobId, err)
 	}
-	requestInfo.SucceededRequests = 1
+	requestInfo.Succeeded = true
 
-	return &modelValue, nil
+	return job.toProto().(*pb.TrainingJob), nil
 }
 
-func (h *grpcHandler) Stats(_ context.Context, _ *pb.StatsRequest) (*pb.ServerStats, error) {
-	stats := h.getStats()
-	return &stats, nil
+func statsToProto(stats ExecutionStats) *pb.ServerStats {
+	result := pb.ServerStats{
+		SucceededRequests: int32(stats.SucceededRequests),
+		TotalRequests:     int32(stats.TotalRequests),
+		TotalInstances:    int32(stats.TotalInstances),
+		CacheHits:         int32(stats.CacheHits),
+		CacheMisses:       int32(stats.CacheMisses),
+		StorageReads:      int32(stats.StorageReads),
+		StorageWrites:     int32(stats.StorageWrites),
+	}
+	for _, methodStats := range stats.Methods {
+		result.Methods = append(result.Methods, &pb.MethodStats{
+			Protocol:          methodStats.Protocol,
+			Method:            methodStats.Method,
+			SucceededRequests: int32(methodStats.SucceededRequests),
+			TotalRequests:     int32(methodStats.TotalRequests),
+			TotalInstances:    int32(methodStats.TotalInstances),
+		})
+	}
+	for _, tenantStats := range stats.Tenants {
+		result.Tenants = append(result.Tenants, &pb.TenantStats{
+			Tenant:            tenantStats.Tenant,
+			SucceededRequests: int32(tenantStats.SucceededRequests),
+			TotalRequests:     int32(tenantStats.TotalRequests),
+			TotalInstances:    int32(tenantStats.TotalInstances),
+		})
+	}
+	for _, windowStats := range stats.Windows {
+		result.Windows = append(result.Windows, &pb.WindowStats{
+			Window:         windowStats.Window,
+			TotalRequests:  int32(windowStats.TotalRequests),
+			FailedRequests: int32(windowStats.FailedRequests),
+			RequestRate:    windowStats.RequestRate,
+			ErrorRate:      windowStats.ErrorRate,
+			LatencyP50:     windowStats.LatencyP50,
+			LatencyP95:     windowStats.LatencyP95,
+			LatencyP99:     windowStats.LatencyP99,
+		})
+	}
+	return &result
+}
+
+func (h *grpcHandler) Stats(ctx context.Context, _ *pb.StatsRequest) (*pb.ServerStats, error) {
+	requestInfo := h.stats.startRequest(h.protocol, statsMode)
+	defer h.stats.finishRequest(requestInfo)
+
+	stats := statsToProto(h.stats.getTenantStats(statsTenantFilter(ctx)))
+	requestInfo.Succeeded = true
+	return stats, nil
 }
 
 func runGRPCHandler() {
-	ctx, err := handlerContext(grpcMode)
+	config, err := loadHandlerConfig(grpcMode)
 	if err != nil {
-		log.Fatal("cannot create context: ", err)
+		log.Fatal("cannot load config: ", err)
 	}
 
-	h, err := newGRPCHandler(ctx)
+	h, err := newGRPCHandler(context.Background(), config)
 	if err != nil {
 		log.Fatal("cannot create handler: ", err)
 	}
 
-	address := ctx.Value("address")
-	lis, err := net.Listen("tcp", address.(string))
+	lis, err := net.Listen("tcp", config.Address)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	grpcServer := grpc.NewServer()
+	tlsConfig, err := config.TLS.newTLSConfig()
+	if err != nil {
+		log.Fatal("cannot configure TLS: ", err)
+	}
+
+	if len(config.MetricsAddress) > 0 {
+		go runMetricsHandler(config.MetricsAddress, withAuth(&config.Auth, statsScope, h.stats.metrics.handler()), tlsConfig)
+	}
+
+	healthServer := health.NewServer()
+	go h.updateHealthLoop(healthServer)
+
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(h.authUnaryInterceptor, h.requestTimeoutInterceptor),
+		grpc.ChainStreamInterceptor(h.authStreamInterceptor),
+		grpc.MaxRecvMsgSize(int(config.MaxRequestBytes)),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	grpcServer := grpc.NewServer(opts...)
 	pb.RegisterRegressionService(grpcServer, h.Svc())
-	grpcServer.Serve(lis)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	reflection.Register(grpcServer)
+
+	serveErrors := make(chan error, 1)
+	go func() {
+		serveErrors <- grpcServer.Serve(lis)
+	}()
+
+	select {
+	case err := <-serveErrors:
+		log.Fatalf("failed to serve: %v", err)
+	case sig := <-shutdownSignals():
+		log.Printf("received %v, draining in-flight requests", sig)
+	}
+
+	deadline := time.Now().Add(config.ShutdownTimeout)
+	healthServer.Shutdown()
+	drained := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(time.Until(deadline)):
+		log.Printf("could not drain in-flight requests in time")
+		grpcServer.Stop()
+	}
+
+	releaseHandlerResources(h.stats, h.jobs, h.modelsStorage, deadline)
 }
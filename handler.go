@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"math"
 )
 
 type protocolMode int
@@ -31,48 +33,156 @@ func handlerModeArg(mode protocolMode) string {
 	return "--" + handlerMode(mode)
 }
 
-func handlerContext(mode protocolMode) (context.Context, error) {
+func loadHandlerConfig(mode protocolMode) (*handlerConfig, error) {
 	flag.Bool(handlerMode(mode), true, "run the regression service")
 
-	project := flag.String("spanner-project", "", "Spanner project name")
-	instance := flag.String("spanner-instance", "", "Spanner instance name")
-	database := flag.String("spanner-database", "", "Spanner database name")
+	config := defaultServiceConfig()
+	hc := &config.Handler
+	flag.StringVar(&hc.Storage.Project, "spanner-project", hc.Storage.Project, "Spanner project name")
+	flag.StringVar(&hc.Storage.Instance, "spanner-instance", hc.Storage.Instance, "Spanner instance name")
+	flag.StringVar(&hc.Storage.Database, "spanner-database", hc.Storage.Database, "Spanner database name")
+	flag.IntVar(&hc.Storage.MaxCache, "max-cache", hc.Storage.MaxCache, "maximum number of models kept in the local cache")
+	flag.DurationVar(&hc.Storage.IdempotencyTTL, "idempotency-ttl", hc.Storage.IdempotencyTTL, "time the idempotency keys of the training requests are kept for")
+	flag.DurationVar(&hc.ShutdownTimeout, "shutdown-timeout", hc.ShutdownTimeout, "time to drain in-flight requests on shutdown")
+	flag.DurationVar(&hc.RequestTimeout, "request-timeout", hc.RequestTimeout, "maximum time to process a single request")
+	flag.IntVar(&hc.MaxInstances, "max-instances", hc.MaxInstances, "maximum number of instances in a training request, 0 for no limit")
+	flag.Int64Var(&hc.MaxRequestBytes, "max-request-bytes", hc.MaxRequestBytes, "maximum size of a request body in bytes")
+	flag.StringVar(&hc.TLS.CertFile, "tls-cert", hc.TLS.CertFile, "certificate file to serve TLS")
+	flag.StringVar(&hc.TLS.KeyFile, "tls-key", hc.TLS.KeyFile, "private key file to serve TLS")
+	flag.StringVar(&hc.TLS.CAFile, "tls-ca", hc.TLS.CAFile, "CA file to verify client certificates")
+	flag.BoolVar(&hc.TLS.ClientAuth, "tls-client-auth", hc.TLS.ClientAuth, "require and verify client certificates")
+	flag.Float64Var(&hc.Limits.CalcRate, "calc-rate", hc.Limits.CalcRate, "calculation requests per second allowed for a single client, 0 for no limit")
+	flag.IntVar(&hc.Limits.CalcBurst, "calc-burst", hc.Limits.CalcBurst, "calculation requests burst allowed for a single client")
+	flag.IntVar(&hc.Limits.ModelsPerDay, "models-per-day", hc.Limits.ModelsPerDay, "models a single client may store per day, 0 for no limit")
+	flag.Var(&hc.InvalidInstances, "invalid-instances", "handling of invalid training instances: reject, skip or clamp")
+	flag.IntVar(&hc.Jobs.Workers, "job-workers", hc.Jobs.Workers, "number of training jobs processed concurrently")
+	flag.IntVar(&hc.Jobs.QueueSize, "job-queue-size", hc.Jobs.QueueSize, "number of training jobs waiting for a worker")
+	flag.DurationVar(&hc.Jobs.Timeout, "job-timeout", hc.Jobs.Timeout, "maximum time to process a single training job, 0 for no limit")
+	flag.DurationVar(&hc.Jobs.Retention, "job-retention", hc.Jobs.Retention, "time the finished training jobs are kept for polling")
+	flag.StringVar(&hc.Jobs.SpoolDir, "job-spool-dir", hc.Jobs.SpoolDir, "directory keeping the uploaded training data of the queued jobs and the uploaded Parquet and Arrow files")
+	flag.BoolVar(&hc.Storage.ContentAddressedNames, "content-addressed-names", hc.Storage.ContentAddressedNames, "derive the model names from the models and their training data instead of random names")
+	configFlags := []string{"spanner-project", "spanner-instance", "spanner-database", "max-cache", "idempotency-ttl", "content-addressed-names",
+		"shutdown-timeout", "request-timeout", "max-instances", "max-request-bytes", "tls-cert", "tls-key", "tls-ca", "tls-client-auth",
+		"calc-rate", "calc-burst", "models-per-day", "invalid-instances",
+		"job-workers", "job-queue-size", "job-timeout", "job-retention", "job-spool-dir"}
 
-	var port, address *string
 	if mode == httpMode {
-		port = flag.String("port", "8080", "run the http handler using this port")
+		flag.StringVar(&hc.Port, "port", hc.Port, "run the http handler using this port")
+		flag.DurationVar(&hc.ReadTimeout, "read-timeout", hc.ReadTimeout, "maximum time to read a request, 0 for no limit")
+		flag.DurationVar(&hc.WriteTimeout, "write-timeout", hc.WriteTimeout, "maximum time to write a response, 0 for no limit")
+		configFlags = append(configFlags, "port", "read-timeout", "write-timeout")
 	}
 	if mode == grpcMode {
-		address = flag.String("address", "localhost:8081", "run the grpc handler using this address")
+		flag.StringVar(&hc.Address, "address", hc.Address, "run the grpc handler using this address")
+		flag.StringVar(&hc.MetricsAddress, "metrics-address", hc.MetricsAddress, "serve the prometheus metrics over http using this address")
+		configFlags = append(configFlags, "address", "metrics-address")
 	}
-	flag.Parse()
 
-	if len(*project) == 0 {
+	if err := parseConfig(&config, configFlags); err != nil {
+		return nil, err
+	}
+
+	if len(hc.Storage.Project) == 0 {
 		return nil, errors.New("choose the spanner project (--spanner-project)")
 	}
-	if len(*instance) == 0 {
+	if len(hc.Storage.Instance) == 0 {
 		return nil, errors.New("choose the spanner instance (--spanner-instance)")
 	}
-	if len(*database) == 0 {
+	if len(hc.Storage.Database) == 0 {
 		return nil, errors.New("choose the spanner database (--spanner-database)")
 	}
+	if hc.MaxRequestBytes <= 0 || hc.MaxRequestBytes > math.MaxInt32 {
+		return nil, errors.New("maximum request size must be positive and fit into 2GiB (--max-request-bytes)")
+	}
+	if hc.Storage.MaxCache <= 0 {
+		return nil, errors.New("models cache size must be positive (--max-cache)")
+	}
+	if hc.Storage.IdempotencyTTL <= 0 {
+		return nil, errors.New("idempotency keys TTL must be positive (--idempotency-ttl)")
+	}
+	if hc.Jobs.Workers <= 0 {
+		return nil, errors.New("number of job workers must be positive (--job-workers)")
+	}
+	if hc.Jobs.QueueSize < 0 {
+		return nil, errors.New("job queue size must not be negative (--job-queue-size)")
+	}
+	if hc.Jobs.Retention <= 0 {
+		return nil, errors.New("job retention must be positive (--job-retention)")
+	}
+	if mode == httpMode && len(hc.Port) == 0 {
+		return nil, errors.New("choose the port for the http daemon (--port)")
+	}
+	if mode == grpcMode && len(hc.Address) == 0 {
+		return nil, errors.New("choose the address for the grpc daemon (--address)")
+	}
+	if _, err := hc.TLS.newTLSConfig(); err != nil {
+		return nil, err
+	}
+	if err := hc.Auth.validate(); err != nil {
+		return nil, err
+	}
+	if err := hc.InvalidInstances.validate(); err != nil {
+		return nil, err
+	}
 
-	ctx := context.Background()
-	ctx = context.WithValue(ctx, "project", *project)
-	ctx = context.WithValue(ctx, "instance", *instance)
-	ctx = context.WithValue(ctx, "database", *database)
+	return hc, nil
+}
 
-	if port != nil {
-		ctx = context.WithValue(ctx, "port", *port)
-	} else if mode == httpMode {
-		return nil, errors.New("choose the port for the http daemon (--port)")
+// withRequestTimeout limits the lifetime of the request's context.
+func withRequestTimeout(ctx context.Context, config *handlerConfig) (context.Context, context.CancelFunc) {
+	if config.RequestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, config.RequestTimeout)
+}
+
+func checkInstancesLimit(config *handlerConfig, instancesCount int) error {
+	if config.MaxInstances > 0 && instancesCount > config.MaxInstances {
+		return &quotaError{fmt.Sprintf("too many instances: %v, at most %v are allowed", instancesCount, config.MaxInstances)}
 	}
+	return nil
+}
+
+// trainingSource passes the training instances to consume one by one with their positions in the source,
+// as decodeTrainingInstances does.
+type trainingSource func(consume func(position int, instance []float64) error) (int, error)
 
-	if address != nil {
-		ctx = context.WithValue(ctx, "address", *address)
-	} else if mode == grpcMode {
-		return nil, errors.New("choose the port for the http daemon (--address)")
+// trainInstances trains the model on the source's instances of the feature, the target and the optional weight,
+// dropping or clamping the invalid ones with the validator, and fingerprints the instances trained on.
+func trainInstances(source trainingSource, validator *instanceValidator) (*TrainingResults, error) {
+	var slr SimpleLinearRegression
+	fingerprint := newDataFingerprint()
+	acceptedCount := 0
+	_, err := source(func(position int, instance []float64) error {
+		weight := 1.0
+		if len(instance) == 3 {
+			weight = instance[2]
+		} else if len(instance) != 2 {
+			return fmt.Errorf("error processing instance #%v: must contain two or three elements", position)
+		}
+		weight, ok, err := validator.check(position, instance[0], instance[1], weight)
+		if ok {
+			slr.AddWeightedInstance(instance[0], instance[1], weight)
+			fingerprint.add(instance[0], instance[1], weight)
+			acceptedCount++
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if acceptedCount == 0 {
+		return nil, errors.New("no valid instances to train on")
 	}
 
-	return ctx, nil
+	results := &TrainingResults{
+		Model: slr.Train(),
+		SumSquaredErrors: slr.SumSquaredErrors(),
+		DataFingerprint: fingerprint.String(),
+	}
+	validator.fill(results)
+	if err := checkTrainedModel(results.Model); err != nil {
+		return nil, err
+	}
+	return results, nil
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	readinessCheckTimeout  = 5 * time.Second
+	readinessCheckInterval = 10 * time.Second
+
+	regressionServiceName = "linear_regression.Regression"
+)
+
+func checkReadiness(ctx context.Context, ms *modelsStorage) error {
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+
+	return ms.checkConnectivity(ctx)
+}
+
+// handleLivenessRequest reports that the process is up and able to serve http requests.
+func handleLivenessRequest(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "ok")
+}
+
+func (h *httpHandler) handleReadinessRequest(w http.ResponseWriter, r *http.Request) {
+	if err := checkReadiness(r.Context(), h.modelsStorage); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "ok")
+}
+
+// updateHealthLoop periodically checks the storage connectivity and reports it via the standard gRPC health service.
+func (h *grpcHandler) updateHealthLoop(healthServer *health.Server) {
+	for {
+		status := healthpb.HealthCheckResponse_SERVING
+		if err := checkReadiness(context.Background(), h.modelsStorage); err != nil {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		healthServer.SetServingStatus("", status)
+		healthServer.SetServingStatus(regressionServiceName, status)
+
+		time.Sleep(readinessCheckInterval)
+	}
+}
@@ -5,11 +5,15 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+
+	pb "linear_regression_service/github.com/ashagraev/linear_regression"
 )
 
 func newTrainingHTTPClient() *regressionClient {
@@ -24,31 +28,63 @@ func newStatsHTTPClient() *regressionClient {
 	return newRegressionClient(statsMode, httpMode)
 }
 
-func (rc *regressionClient) requestHTTPTraining(instances [][]float64) (string, error) {
-	data, err := json.Marshal(instances)
+func (rc *regressionClient) requestHTTPTraining(instances []*pb.Instance) (string, error) {
+	jsonInstances := make([][]float64, 0, len(instances))
+	for _, instance := range instances {
+		jsonInstances = append(jsonInstances, []float64{instance.Argument, instance.Target, instance.Weight})
+	}
+	data, err := json.Marshal(jsonInstances)
 	if err != nil {
 		return "", fmt.Errorf("can't marshal instances: %v", err)
 	}
 
 	dataReader := bytes.NewReader(data)
-	resp, err := http.Post(rc.serverPath + "/train?store=1", "application/json", dataReader)
+	req, err := http.NewRequest(http.MethodPost, rc.serverPath + "/train?store=1" + rc.tenantQuery(), dataReader)
 	if err != nil {
-		return "", fmt.Errorf("error processing /train: %v", err)
+		return "", fmt.Errorf("can't create /train request: %v", err)
 	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(rc.idempotencyKey) > 0 {
+		req.Header.Set(idempotencyKeyHeader, rc.idempotencyKey)
+	}
+	return rc.doHTTPRequest(req, "train")
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("can't load /train answer: %v", err)
+// requestHTTPColumnarTraining uploads the Parquet or Arrow training data as is, so that the server decodes it without
+// converting it to JSON; the columns are chosen by the client's format and the invalid instances by the server's policy.
+func (rc *regressionClient) requestHTTPColumnarTraining(reader io.Reader, kind columnarKind) (string, error) {
+	query := url.Values{}
+	query.Set("store", "1")
+	if len(rc.tenant) > 0 {
+		query.Set("tenant", rc.tenant)
+	}
+	for param, column := range map[string]string{"feature": rc.format.FeatureColumn, "target": rc.format.TargetColumn, "weight": rc.format.WeightColumn} {
+		if len(column) > 0 {
+			query.Set(param, column)
+		}
 	}
 
-	return string(body), nil
+	req, err := http.NewRequest(http.MethodPost, rc.serverPath + "/train?" + query.Encode(), reader)
+	if err != nil {
+		return "", fmt.Errorf("can't create /train request: %v", err)
+	}
+	req.Header.Set("Content-Type", columnarMediaType(kind))
+	if len(rc.idempotencyKey) > 0 {
+		req.Header.Set(idempotencyKeyHeader, rc.idempotencyKey)
+	}
+	return rc.doHTTPRequest(req, "train")
 }
 
-func requestHTTPMethod(url string, method string) (string, error) {
-	resp, err := http.Get(url)
+func (rc *regressionClient) doHTTPRequest(req *http.Request, method string) (string, error) {
+	if len(rc.apiKey) > 0 {
+		req.Header.Set("Authorization", "Bearer " + rc.apiKey)
+	}
+
+	resp, err := rc.httpClient.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("error processing /%v: %v", method, err)
 	}
+	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -58,25 +94,54 @@ func requestHTTPMethod(url string, method string) (string, error) {
 	return string(body), nil
 }
 
+func (rc *regressionClient) requestHTTPMethod(url string, method string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("can't create /%v request: %v", method, err)
+	}
+	return rc.doHTTPRequest(req, method)
+}
+
+func (rc *regressionClient) tenantQuery() string {
+	if len(rc.tenant) == 0 {
+		return ""
+	}
+	return "&tenant=" + url.QueryEscape(rc.tenant)
+}
+
 func (rc *regressionClient) requestHTTPCalculation(arg float64) (string, error) {
-	url := fmt.Sprintf("%v/calc?model=%v&arg=%v", rc.serverPath, rc.modelName, arg)
-	return requestHTTPMethod(url, "calc")
+	url := fmt.Sprintf("%v/calc?model=%v&arg=%v%v", rc.serverPath, url.QueryEscape(rc.modelName), arg, rc.tenantQuery())
+	return rc.requestHTTPMethod(url, "calc")
 }
 
 func (rc *regressionClient) requestHTTPStats() (string, error) {
 	url := fmt.Sprintf("%v/stats", rc.serverPath)
-	return requestHTTPMethod(url, "stats")
+	return rc.requestHTTPMethod(url, "stats")
 }
 
 func runHTTPTraining() {
 	client := newTrainingHTTPClient()
 
-	instances, err := loadInstancesFromTSV(os.Stdin)
+	input, err := openTrainingInput(client.input)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	result, err := client.requestHTTPTraining(instances)
+	defer input.close()
+
+	// Parquet and Arrow files are uploaded as is, the other formats are sent as JSON.
+	buffered := bufio.NewReader(input.reader)
+	input.reader = buffered
+	magic, _ := buffered.Peek(len(arrowFileMagic))
+	var result string
+	if kind, ok := detectColumnarKind(magic); ok {
+		result, err = client.requestHTTPColumnarTraining(buffered, kind)
+	} else {
+		var instances []*pb.Instance
+		if instances, err = client.loadInputInstances(input); err != nil {
+			log.Fatal(err)
+		}
+		result, err = client.requestHTTPTraining(instances)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -1,120 +1,190 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
-	"sync"
 	"time"
-)
-
-// ExecutionStats stores all-time execution statistics for the service.
-type ExecutionStats struct {
-	// SucceededRequests stores the number of successfully processed requests.
-	SucceededRequests int
 
-	// TotalRequests stores the total number of received requests.
-	TotalRequests  int
-
-	// TotalInstances stores the total number of instances used while learning models.
-	TotalInstances int
-}
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+)
 
 type httpHandler struct {
-	stats        ExecutionStats
-	requestStats chan ExecutionStats
-
-	statsMutex sync.Mutex
+	config  *handlerConfig
+	stats   *statsCollector
+	limiter *clientLimiter
 
 	modelsStorage *modelsStorage
+	jobs          *jobsManager
+
+	// transcoder serves the JSON/HTTP bindings of the Regression gRPC service; its marshalers also encode
+	// the responses of the versioned API's upload endpoints.
+	transcoder *runtime.ServeMux
 }
 
-func newHTTPHandler(ctx context.Context) (*httpHandler, error) {
-	modelsStorage, err := newModelsStorage(ctx)
+func newHTTPHandler(ctx context.Context, config *handlerConfig) (*httpHandler, error) {
+	stats := newStatsCollector()
+	modelsStorage, err := newModelsStorage(ctx, config.Storage, stats)
 	if err != nil {
 		return nil, err
 	}
-	h := httpHandler{requestStats: make(chan ExecutionStats), modelsStorage: modelsStorage}
-	go h.updateStatsLoop()
-	return &h, nil
+	limiter := newClientLimiter(&config.Limits)
+	h := &httpHandler{
+		config:        config,
+		stats:         stats,
+		limiter:       limiter,
+		modelsStorage: modelsStorage,
+		jobs:          newJobsManager(config, modelsStorage),
+	}
+	h.transcoder, err = newTranscodingHandler(&grpcHandler{
+		config:        config,
+		stats:         stats,
+		limiter:       limiter,
+		modelsStorage: modelsStorage,
+		jobs:          h.jobs,
+		protocol:      httpMode,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
 }
 
-func (h* httpHandler) getStats() ExecutionStats {
-	h.statsMutex.Lock()
-	defer h.statsMutex.Unlock()
+// withRequestTimeout limits the processing time of the requests served by the given handler.
+func (h *httpHandler) withRequestTimeout(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := withRequestTimeout(r.Context(), h.config)
+		defer cancel()
+		handler(w, r.WithContext(ctx))
+	})
+}
 
-	return h.stats
+func reportError(w http.ResponseWriter, message string) {
+	reportStatusError(w, http.StatusInternalServerError, message)
 }
 
-func (h* httpHandler) setStats(stats ExecutionStats) {
-	h.statsMutex.Lock()
-	defer h.statsMutex.Unlock()
+func reportStatusError(w http.ResponseWriter, statusCode int, message string) {
+	w.WriteHeader(statusCode)
+	io.WriteString(w, message)
+	fmt.Fprintln(os.Stderr, message)
+}
 
-	h.stats = stats
+// reportLimitError answers 429 for the exceeded rate limits and quotas.
+func reportLimitError(w http.ResponseWriter, err error) {
+	if err == errRateLimited {
+		w.Header().Set("Retry-After", "1")
+	}
+	reportStatusError(w, http.StatusTooManyRequests, err.Error())
 }
 
-func (h *httpHandler) updateStatsLoop() {
-	for r := range h.requestStats {
-		stats := h.getStats()
-		stats.TotalRequests += r.TotalRequests
-		stats.TotalInstances += r.TotalInstances
-		stats.SucceededRequests += r.SucceededRequests
-		h.setStats(stats)
+// reportTrainingDataError chooses the status code for an error occurred while reading the training data.
+func reportTrainingDataError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	var quotaErr *quotaError
+	switch {
+	case errors.As(err, &tooLarge):
+		reportStatusError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %v bytes", tooLarge.Limit))
+	case errors.As(err, &quotaErr):
+		reportLimitError(w, err)
+	case err == errUnsupportedMediaType:
+		reportStatusError(w, http.StatusUnsupportedMediaType, err.Error())
+	default:
+		reportStatusError(w, http.StatusBadRequest, err.Error())
 	}
 }
 
-func reportError(w http.ResponseWriter, message string) {
-	w.WriteHeader(500)
-	io.WriteString(w, message)
-	fmt.Fprintln(os.Stderr, message)
+// resolveHTTPTenant chooses the tenant by the request's tenant key and reports the error if it is not allowed.
+func resolveHTTPTenant(w http.ResponseWriter, r *http.Request, requestInfo *requestStats) (string, bool) {
+	tenant, err := resolveTenant(r.Context(), r.URL.Query().Get("tenant"))
+	if err == errTenantForbidden {
+		reportStatusError(w, http.StatusForbidden, err.Error())
+		return "", false
+	}
+	if err != nil {
+		reportStatusError(w, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+	requestInfo.Tenant = tenant
+	return tenant, true
 }
 
 func reportFormatError(w http.ResponseWriter, format string, args ...interface{}) {
 	w.WriteHeader(500)
-	io.WriteString(w, fmt.Sprintf(format, args))
-	fmt.Fprintln(os.Stderr, fmt.Sprintf(format, args))
+	io.WriteString(w, fmt.Sprintf(format, args...))
+	fmt.Fprintln(os.Stderr, fmt.Sprintf(format, args...))
 }
 
-func reportJSON(value interface{}, name string, w http.ResponseWriter) {
-	simpleJSON, err := json.Marshal(value)
-	if err != nil {
-		reportFormatError(w, "could not marshal %v", name)
-		return
+func (h *httpHandler) handleStatsRequest(w http.ResponseWriter, r *http.Request) {
+	requestInfo := h.stats.startRequest(httpMode, statsMode)
+	defer h.stats.finishRequest(requestInfo)
+
+	stats := h.stats.getTenantStats(statsTenantFilter(r.Context()))
+	reportResponse(w, r, &stats, "stats")
+	requestInfo.Succeeded = true
+}
+
+func storeModelRequested(r *http.Request) bool {
+	storeNeeded := r.URL.Query().Get("store")
+	return storeNeeded == "1" || storeNeeded == "true"
+}
+
+// trainingDataFormat chooses the columns of the delimited and columnar training data by the request's
+// feature, target and weight keys, and the header presence by its header key.
+func trainingDataFormat(r *http.Request) delimitedFormat {
+	query := r.URL.Query()
+	header := query.Get("header")
+	return delimitedFormat{
+		Header:        header == "1" || header == "true",
+		FeatureColumn: query.Get("feature"),
+		TargetColumn:  query.Get("target"),
+		WeightColumn:  query.Get("weight"),
 	}
+}
 
-	var prettyJson bytes.Buffer
-	if err := json.Indent(&prettyJson, simpleJSON, "", "    "); err != nil {
-		reportFormatError(w, "could indent %v json", name)
+// reportModelError answers 404 for the missing models and 500 for the storage failures.
+func reportModelError(w http.ResponseWriter, modelName string, err error) {
+	if err == errModelNotFound {
+		reportStatusError(w, http.StatusNotFound, fmt.Sprintf("model %v is not found", modelName))
 		return
 	}
-
-	w.WriteHeader(200)
-	io.WriteString(w, prettyJson.String())
+	reportFormatError(w, "error loading model %v: %v", modelName, err)
 }
 
-func (h *httpHandler) handleStatsRequest(w http.ResponseWriter, _ *http.Request) {
-	reportJSON(h.getStats(), "stats", w)
-}
+// calculateModel calculates the value of the tenant's model over the argument, reporting the errors itself.
+func (h *httpHandler) calculateModel(w http.ResponseWriter, r *http.Request, requestInfo *requestStats, modelName string, arg float64) (*ModelValue, bool) {
+	tenant, ok := resolveHTTPTenant(w, r, requestInfo)
+	if !ok {
+		return nil, false
+	}
 
-func storeModelRequested(r *http.Request) bool {
-	storeNeeded := r.URL.Query().Get("store")
-	return storeNeeded == "1" || storeNeeded == "true"
+	if err := h.limiter.allowCalculation(clientID(r.Context(), r.RemoteAddr)); err != nil {
+		reportLimitError(w, err)
+		return nil, false
+	}
+
+	model, fromCache, err := h.modelsStorage.getSLRModel(r.Context(), tenant, modelName)
+	if err != nil {
+		reportModelError(w, modelName, err)
+		return nil, false
+	}
+
+	return &ModelValue{
+		Value:           model.Calculate(arg),
+		Argument:        arg,
+		Model:           model,
+		CalculationTime: time.Now().UTC(),
+		FromCache:       fromCache,
+	}, true
 }
 
 func (h* httpHandler) handleCalculationRequest(w http.ResponseWriter, r *http.Request) {
-	requestInfo := ExecutionStats{
-		TotalRequests: 1,
-	}
-	defer func() {
-		h.requestStats <- requestInfo
-	}()
+	requestInfo := h.stats.startRequest(httpMode, calculateMode)
+	defer h.stats.finishRequest(requestInfo)
 
 	argStr := r.URL.Query().Get("arg")
 	if len(argStr) == 0 {
@@ -128,99 +198,189 @@ func (h* httpHandler) handleCalculationRequest(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	model, fromCache, err := h.modelsStorage.getSLRModel(r.Context(), modelName)
-	if err != nil {
-		reportFormatError(w, "error loading model %v: %v", modelName, err)
-		return
-	}
-
 	arg, err := strconv.ParseFloat(argStr, 64)
 	if err != nil {
 		reportFormatError(w, "error converting arg parameter to float: %v", argStr)
 		return
 	}
 
-	modelValue := ModelValue{
-		Value:           model.Calculate(arg),
-		Argument:        arg,
-		Model:           model,
-		CalculationTime: time.Now(),
-		FromCache:       fromCache,
+	modelValue, ok := h.calculateModel(w, r, requestInfo, modelName, arg)
+	if !ok {
+		return
 	}
-	requestInfo.SucceededRequests = 1
+	requestInfo.Succeeded = true
 
-	reportJSON(modelValue, modelName, w)
+	reportResponse(w, r, modelValue, modelName)
 }
 
-func (h *httpHandler) handleTrainingRequest(w http.ResponseWriter, r *http.Request) {
-	requestInfo := ExecutionStats{
-		TotalRequests: 1,
+// trainModel trains the model on the request's body and stores it if needed, reporting the errors itself.
+// The storage errors are returned in the training results. The requests storing the model with the Idempotency-Key
+// header used before are answered with the stored results, marked by the Idempotent-Replayed header,
+// or with 422 Unprocessable Entity if the key was used for different training data.
+func (h *httpHandler) trainModel(w http.ResponseWriter, r *http.Request, requestInfo *requestStats, storeModel bool) (*TrainingResults, bool) {
+	tenant, ok := resolveHTTPTenant(w, r, requestInfo)
+	if !ok {
+		return nil, false
 	}
-	defer func() {
-		h.requestStats <- requestInfo
-	}()
 
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		reportError(w,"could not load request's body")
-		return
+	idempotencyKey := ""
+	if storeModel {
+		idempotencyKey = r.Header.Get(idempotencyKeyHeader)
+		if err := checkIdempotencyKey(idempotencyKey); err != nil {
+			reportStatusError(w, http.StatusBadRequest, err.Error())
+			return nil, false
+		}
 	}
-
-	var instances [][]float64
-	if err := json.Unmarshal(body, &instances); err != nil {
-		reportError(w,"could not load json")
-		return
+	// The replayed requests are trained again to check their data, but are not counted against the quota.
+	var storedResults *TrainingResults
+	if len(idempotencyKey) > 0 {
+		var err error
+		if storedResults, err = h.modelsStorage.findIdempotentResults(r.Context(), tenant, idempotencyKey); err != nil {
+			reportError(w, err.Error())
+			return nil, false
+		}
 	}
-	requestInfo.TotalInstances = len(instances)
 
-	var slr SimpleLinearRegression
-	for idx, instance := range instances {
-		if len(instance) == 2 {
-			slr.AddInstance(instance[0], instance[1])
-		} else if len(instance) == 3 {
-			slr.AddWeightedInstance(instance[0], instance[1], instance[2])
-		} else {
-			reportFormatError(w,"error processing instance #%v: must contain exactly two elements", idx)
-			return
+	var reservation *quotaReservation
+	if storeModel && storedResults == nil {
+		var err error
+		reservation, err = h.limiter.reserveStoredModel(clientID(r.Context(), r.RemoteAddr))
+		if err != nil {
+			reportLimitError(w, err)
+			return nil, false
 		}
+		defer reservation.release()
 	}
 
-	trainingResults := TrainingResults{
-		Model: slr.Train(),
-		SumSquaredErrors: slr.SumSquaredErrors(),
+	body := http.MaxBytesReader(w, r.Body, h.config.MaxRequestBytes)
+	trainingResults, err := trainInstances(func(consume func(position int, instance []float64) error) (int, error) {
+		readCount := 0
+		instancesCount, err := decodeTrainingInstances(r.Header.Get("Content-Type"), trainingDataFormat(r), body, h.config.Jobs.SpoolDir, func(position int, instance []float64) error {
+			readCount++
+			if err := checkInstancesLimit(h.config, readCount); err != nil {
+				return err
+			}
+			return consume(position, instance)
+		})
+		requestInfo.Instances = instancesCount
+		return instancesCount, err
+	}, newInstanceValidator(h.config.InvalidInstances))
+	if err != nil {
+		reportTrainingDataError(w, err)
+		return nil, false
 	}
 
-	if storeModelRequested(r) {
-		name, commitTime, err := h.modelsStorage.saveSLRModel(r.Context(), trainingResults.Model)
-		if err != nil {
-			trainingResults.Error = fmt.Sprintf("%v", err)
-		}
-		trainingResults.Name = name
-		trainingResults.CreationTime = commitTime
+	replayed := false
+	if storedResults != nil {
+		trainingResults, err = replayIdempotentResults(storedResults, trainingResults)
+		replayed = true
+	} else if storeModel {
+		trainingResults, replayed, err = storeTrainingResults(r.Context(), h.modelsStorage, reservation, tenant, idempotencyKey, trainingResults)
+	}
+	if err != nil {
+		reportStatusError(w, http.StatusUnprocessableEntity, err.Error())
+		return nil, false
+	}
+	if replayed {
+		w.Header().Set(idempotentReplayHeader, "true")
 	}
-	reportJSON(trainingResults, "training results", w)
+	return trainingResults, true
+}
 
-	requestInfo.SucceededRequests = 1
+func (h *httpHandler) handleTrainingRequest(w http.ResponseWriter, r *http.Request) {
+	requestInfo := h.stats.startRequest(httpMode, trainMode)
+	defer h.stats.finishRequest(requestInfo)
+
+	trainingResults, ok := h.trainModel(w, r, requestInfo, storeModelRequested(r))
+	if !ok {
+		return
+	}
+	reportResponse(w, r, trainingResults, "training results")
+
+	requestInfo.Succeeded = len(trainingResults.Error) == 0
+}
+
+// withMethod serves the requests of the given method with the handler and passes the others to the fallback.
+// The method-prefixed ServeMux patterns are not used, as the GOPATH builds take them for literal paths.
+func withMethod(method string, handler http.Handler, fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// newHTTPMux routes both the versioned API and the legacy query-string endpoints.
+func (h *httpHandler) newHTTPMux() *http.ServeMux {
+	auth := &h.config.Auth
+	mux := http.NewServeMux()
+
+	// The versioned API is transcoded to the gRPC service, except for the training data uploads
+	// which are not expressible as JSON messages; the transcoder authorizes the requests itself.
+	mux.Handle("/v1/models", withMethod(http.MethodPost, withAuth(auth, trainScope, h.withRequestTimeout(h.handleCreateModelRequest)), h.transcoder))
+	mux.Handle("/v1/jobs", withMethod(http.MethodPost, withAuth(auth, trainScope, h.withRequestTimeout(h.handleSubmitJobRequest)), h.transcoder))
+	mux.Handle("/v1/", h.transcoder)
+
+	// The legacy endpoints are kept for the existing clients.
+	mux.Handle("/train", withAuth(auth, trainScope, h.withRequestTimeout(h.handleTrainingRequest)))
+	mux.Handle("/calc", withAuth(auth, calcScope, h.withRequestTimeout(h.handleCalculationRequest)))
+	mux.Handle("/stats", withAuth(auth, statsScope, h.withRequestTimeout(h.handleStatsRequest)))
+
+	mux.Handle("/metrics", withAuth(auth, statsScope, h.stats.metrics.handler()))
+	mux.Handle("/healthz", http.HandlerFunc(handleLivenessRequest))
+	mux.Handle("/readyz", http.HandlerFunc(h.handleReadinessRequest))
+	mux.Handle("/openapi.json", http.HandlerFunc(handleOpenAPIRequest))
+	return mux
 }
 
 func runHTTPHandler() {
-	ctx, err := handlerContext(httpMode)
+	config, err := loadHandlerConfig(httpMode)
 	if err != nil {
-		log.Fatal("cannot create context: ", err)
+		log.Fatal("cannot load config: ", err)
 	}
 
-	h, err := newHTTPHandler(ctx)
+	h, err := newHTTPHandler(context.Background(), config)
 	if err != nil {
 		log.Fatal("cannot create handler: ", err)
 	}
 
-	http.Handle("/train", http.HandlerFunc(h.handleTrainingRequest))
-	http.Handle("/calc", http.HandlerFunc(h.handleCalculationRequest))
-	http.Handle("/stats", http.HandlerFunc(h.handleStatsRequest))
-
-	port := ctx.Value("port")
-	err = http.ListenAndServe(":" + port.(string), nil)
+	tlsConfig, err := config.TLS.newTLSConfig()
 	if err != nil {
+		log.Fatal("cannot configure TLS: ", err)
+	}
+
+	server := &http.Server{
+		Addr:         ":" + config.Port,
+		Handler:      h.newHTTPMux(),
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+		TLSConfig:    tlsConfig,
+	}
+	serveErrors := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			serveErrors <- server.ListenAndServeTLS("", "")
+		} else {
+			serveErrors <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErrors:
 		log.Fatal("ListenAndServe:", err)
+	case sig := <-shutdownSignals():
+		log.Printf("received %v, draining in-flight requests", sig)
 	}
+
+	deadline := time.Now().Add(config.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("could not drain in-flight requests: %v", err)
+		server.Close()
+	}
+
+	releaseHandlerResources(h.stats, h.jobs, h.modelsStorage, deadline)
 }
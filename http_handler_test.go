@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pb "linear_regression_service/github.com/ashagraev/linear_regression"
+)
+
+// newTestHTTPHandler creates the handler as newHTTPHandler does, but without the models storage,
+// so that only the requests not touching the storage may be served.
+func newTestHTTPHandler(t *testing.T, config *handlerConfig) *httpHandler {
+	t.Helper()
+	stats := newStatsCollector()
+	limiter := newClientLimiter(&config.Limits)
+	h := &httpHandler{
+		config:  config,
+		stats:   stats,
+		limiter: limiter,
+		jobs:    newJobsManager(config, nil),
+	}
+	var err error
+	h.transcoder, err = newTranscodingHandler(&grpcHandler{
+		config:   config,
+		stats:    stats,
+		limiter:  limiter,
+		jobs:     h.jobs,
+		protocol: httpMode,
+	})
+	if err != nil {
+		t.Fatalf("cannot create transcoder: %v", err)
+	}
+	t.Cleanup(func() {
+		h.jobs.close(time.Now().Add(time.Second))
+	})
+	return h
+}
+
+// serveTestRequest serves the request by the handler's mux and returns the response.
+func serveTestRequest(h *httpHandler, method string, target string, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i + 1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i + 1])
+	}
+	w := httptest.NewRecorder()
+	h.newHTTPMux().ServeHTTP(w, r)
+	return w
+}
+
+func TestTrainingRequestLimits(t *testing.T) {
+	config := defaultServiceConfig().Handler
+	config.MaxRequestBytes = 64
+	config.MaxInstances = 3
+	h := newTestHTTPHandler(t, &config)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{"json", "", "[[1, 2], [2, 4], [3, 6]]", http.StatusOK},
+		{"json with charset", "application/json; charset=utf-8", "[[1, 2], [2, 4]]", http.StatusOK},
+		{"tsv", "text/tab-separated-values", "1\t2\n2\t4\n", http.StatusOK},
+		{"too large body", "", "[[1, 2]" + strings.Repeat(" ", 64) + "]", http.StatusRequestEntityTooLarge},
+		{"too many instances", "", "[[1, 2], [2, 4], [3, 6], [4, 8]]", http.StatusTooManyRequests},
+		{"malformed json", "", "[[1, 2], [2, ", http.StatusBadRequest},
+		{"json object", "", `{"instances": []}`, http.StatusBadRequest},
+		{"bad instance", "", "[[1, 2, 3, 4]]", http.StatusBadRequest},
+		{"unsupported media type", "text/plain", "1 2\n", http.StatusUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		w := serveTestRequest(h, http.MethodPost, "/train", test.body, "Content-Type", test.contentType, "Accept", "application/json")
+		if w.Code != test.wantStatus {
+			t.Errorf("%v: status %v (%v), want %v", test.name, w.Code, strings.TrimSpace(w.Body.String()), test.wantStatus)
+		}
+	}
+}
+
+func TestGRPCTrainingRequestLimits(t *testing.T) {
+	config := defaultServiceConfig().Handler
+	config.MaxInstances = 2
+	h := &grpcHandler{config: &config, stats: newStatsCollector(), limiter: newClientLimiter(&config.Limits), protocol: grpcMode}
+
+	instances := []*pb.Instance{{Argument: 1, Target: 2, Weight: 1}, {Argument: 2, Target: 4, Weight: 1}}
+	if _, err := h.Train(context.Background(), &pb.TrainingRequest{Instances: instances}); err != nil {
+		t.Errorf("Train() within the limit: %v", err)
+	}
+	instances = append(instances, &pb.Instance{Argument: 3, Target: 6, Weight: 1})
+	if _, err := h.Train(context.Background(), &pb.TrainingRequest{Instances: instances}); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Train() above the limit: %v, want %v", err, codes.ResourceExhausted)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+)
+
+// reportV1Response writes the response of the versioned API's upload endpoints with the transcoder's marshaler
+// chosen by the Accept header, so that they have the same representation as the transcoded responses.
+func (h *httpHandler) reportV1Response(w http.ResponseWriter, r *http.Request, statusCode int, response proto.Message, name string) {
+	_, outbound := runtime.MarshalerForRequest(h.transcoder, r)
+	data, err := outbound.Marshal(response)
+	if err != nil {
+		reportFormatError(w, "could not marshal %v: %v", name, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", outbound.ContentType(response))
+	w.WriteHeader(statusCode)
+	w.Write(data)
+}
+
+// handleCreateModelRequest trains and stores the model on the uploaded training data: POST /v1/models.
+// Unlike the rest of the versioned API it is not transcoded, as the training data may be CSV, Parquet or Arrow.
+func (h *httpHandler) handleCreateModelRequest(w http.ResponseWriter, r *http.Request) {
+	requestInfo := h.stats.startRequest(httpMode, trainMode)
+	defer h.stats.finishRequest(requestInfo)
+
+	trainingResults, ok := h.trainModel(w, r, requestInfo, true)
+	if !ok {
+		return
+	}
+	if len(trainingResults.Error) > 0 {
+		reportError(w, trainingResults.Error)
+		return
+	}
+	requestInfo.Succeeded = true
+
+	w.Header().Set("Location", "/v1/models/" + url.PathEscape(trainingResults.Name))
+	h.reportV1Response(w, r, http.StatusCreated, trainingResults.toProto(), "training results")
+}
+
+// handleSubmitJobRequest spools the uploaded training data and queues the job training and storing the model on it: POST /v1/jobs.
+// The response is sent once the upload is complete, the job is then polled by GET /v1/jobs/{job_id}.
+func (h *httpHandler) handleSubmitJobRequest(w http.ResponseWriter, r *http.Request) {
+	requestInfo := h.stats.startRequest(httpMode, submitJobMode)
+	defer h.stats.finishRequest(requestInfo)
+
+	tenant, ok := resolveHTTPTenant(w, r, requestInfo)
+	if !ok {
+		return
+	}
+	idempotencyKey := r.Header.Get(idempotencyKeyHeader)
+	if err := checkIdempotencyKey(idempotencyKey); err != nil {
+		reportStatusError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	reservation, err := h.limiter.reserveStoredModel(clientID(r.Context(), r.RemoteAddr))
+	if err != nil {
+		reportLimitError(w, err)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if _, err := trainingMediaType(contentType); err != nil {
+		reservation.release()
+		reportTrainingDataError(w, err)
+		return
+	}
+	spool, err := spoolTrainingData(h.config.Jobs.SpoolDir, http.MaxBytesReader(w, r.Body, h.config.MaxRequestBytes))
+	if err != nil {
+		reservation.release()
+		reportTrainingDataError(w, err)
+		return
+	}
+
+	format := trainingDataFormat(r)
+	job, err := h.jobs.submit(tenant, reservation, idempotencyKey, 0, func(consume func(position int, instance []float64) error) (int, error) {
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return 0, fmt.Errorf("cannot read spool file: %v", err)
+		}
+		return decodeTrainingInstances(contentType, format, spool, h.config.Jobs.SpoolDir, consume)
+	}, func() {
+		removeSpoolFile(spool)
+	})
+	if err != nil {
+		removeSpoolFile(spool)
+		reservation.release()
+		var quotaErr *quotaError
+		switch {
+		case errors.As(err, &quotaErr):
+			reportLimitError(w, err)
+		case err == errJobsStopped:
+			reportStatusError(w, http.StatusServiceUnavailable, err.Error())
+		default:
+			reportError(w, err.Error())
+		}
+		return
+	}
+	requestInfo.Succeeded = true
+
+	w.Header().Set("Location", "/v1/jobs/" + url.PathEscape(job.ID))
+	h.reportV1Response(w, r, http.StatusAccepted, job.toProto(), "training job")
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	pb "linear_regression_service/github.com/ashagraev/linear_regression"
+)
+
+// idempotencyKeyHeader carries the idempotency key of the http requests storing the models.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotentReplayHeader marks the http responses returning the results of a previous request with the same idempotency key.
+const idempotentReplayHeader = "Idempotent-Replayed"
+
+const maxIdempotencyKeyLength = 255
+
+var errInvalidIdempotencyKey = fmt.Errorf("idempotency key must consist of at most %v printable ASCII characters", maxIdempotencyKeyLength)
+
+// errIdempotencyKeyReused is reported for the requests with an idempotency key used before for different training data.
+var errIdempotencyKeyReused = errors.New("idempotency key is already used for different training data")
+
+func checkIdempotencyKey(key string) error {
+	if len(key) > maxIdempotencyKeyLength {
+		return errInvalidIdempotencyKey
+	}
+	for _, c := range key {
+		if c < ' ' || c > '~' {
+			return errInvalidIdempotencyKey
+		}
+	}
+	return nil
+}
+
+func trainingResultsFromProto(message *pb.TrainingResults) *TrainingResults {
+	results := &TrainingResults{
+		SumSquaredErrors: message.SumSquaredErrors,
+		Name:             message.Name,
+		Error:            message.Error,
+		DroppedInstances: int(message.DroppedInstances),
+		ClampedInstances: int(message.ClampedInstances),
+		DataFingerprint:  message.DataFingerprint,
+	}
+	if model := message.Model; model != nil {
+		results.Model = &SimpleRegressionModel{Name: model.Name, Coefficient: model.Coefficient, Intercept: model.Intercept}
+	}
+	if message.CreationTime != nil {
+		results.CreationTime = message.CreationTime.AsTime().UTC()
+	}
+	for _, idx := range message.InvalidInstances {
+		results.InvalidInstances = append(results.InvalidInstances, int(idx))
+	}
+	return results
+}
+
+// idempotentResultsColumns are read from the idempotency_keys table; the results are stored without the creation time,
+// which is known only after the commit.
+var idempotentResultsColumns = []string{"results", "creation_time", "expire_time"}
+
+// decodeIdempotentResults returns the results stored under the key, or nil if the key has expired but is not removed yet.
+func decodeIdempotentResults(row *spanner.Row) (*TrainingResults, error) {
+	var data []byte
+	var creationTime, expireTime time.Time
+	if err := row.Columns(&data, &creationTime, &expireTime); err != nil {
+		return nil, fmt.Errorf("error loading idempotency key from Spanner row: %v", err)
+	}
+	if time.Now().After(expireTime) {
+		return nil, nil
+	}
+
+	var message pb.TrainingResults
+	if err := proto.Unmarshal(data, &message); err != nil {
+		return nil, fmt.Errorf("error decoding stored training results: %v", err)
+	}
+	results := trainingResultsFromProto(&message)
+	results.CreationTime = creationTime.UTC()
+	return results, nil
+}
+
+// findIdempotentResults returns the results stored under the tenant's idempotency key, or nil if there are none,
+// so that the retried requests are answered without storing the model again; see replayIdempotentResults.
+func (ms *modelsStorage) findIdempotentResults(ctx context.Context, tenant string, key string) (*TrainingResults, error) {
+	started := time.Now()
+	row, err := ms.spannerClient.Single().ReadRow(ctx, "idempotency_keys", spanner.Key{tenant, key}, idempotentResultsColumns)
+	if spanner.ErrCode(err) == codes.NotFound {
+		ms.stats.reportStorageRead(started, nil)
+		return nil, nil
+	}
+	ms.stats.reportStorageRead(started, err)
+	if err != nil {
+		return nil, fmt.Errorf("error loading idempotency key from Spanner: %v", err)
+	}
+	return decodeIdempotentResults(row)
+}
+
+// replayIdempotentResults returns the results stored under the idempotency key if the repeated request's results
+// are trained on the same data, as their data fingerprints show, and errIdempotencyKeyReused otherwise.
+func replayIdempotentResults(stored *TrainingResults, results *TrainingResults) (*TrainingResults, error) {
+	if stored.DataFingerprint != results.DataFingerprint {
+		return nil, errIdempotencyKeyReused
+	}
+	return stored, nil
+}
+
+// saveIdempotentSLRModel stores the model and the training results under the idempotency key in a single transaction,
+// so that a request retried after a timed out commit finds the key. If the key is already stored, nothing is written
+// and the results stored under it are returned with replayed set, see replayIdempotentResults; created is set
+// if a new model is written, see saveSLRModel.
+func (ms *modelsStorage) saveIdempotentSLRModel(ctx context.Context, tenant string, key string, results *TrainingResults) (_ *TrainingResults, replayed bool, created bool, _ error) {
+	name, err := ms.newModelName(results.Model, results.DataFingerprint)
+	if err != nil {
+		return nil, false, false, err
+	}
+	stored := *results
+	stored.Name = name
+	data, err := proto.Marshal(stored.toProto())
+	if err != nil {
+		return nil, false, false, fmt.Errorf("cannot encode training results: %v", err)
+	}
+
+	var replayedResults *TrainingResults
+	var existingCreationTime time.Time
+	started := time.Now()
+	commitTS, err := ms.spannerClient.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		replayedResults, existingCreationTime = nil, time.Time{}
+		row, err := txn.ReadRow(ctx, "idempotency_keys", spanner.Key{tenant, key}, idempotentResultsColumns)
+		if err == nil {
+			if replayedResults, err = decodeIdempotentResults(row); err != nil || replayedResults != nil {
+				return err
+			}
+		} else if spanner.ErrCode(err) != codes.NotFound {
+			return err
+		}
+
+		var mutations []*spanner.Mutation
+		creationTime := spanner.CommitTimestamp
+		if existingCreationTime, err = ms.existingModelCreationTime(ctx, txn, tenant, name); err != nil {
+			return err
+		}
+		if existingCreationTime.IsZero() {
+			mutations = append(mutations, spanner.Insert("slr_models",
+				[]string{"tenant", "name", "params", "data_fingerprint", "creation_time"},
+				[]interface{}{tenant, name, stored.Model.ToFloatArray(), stored.DataFingerprint, spanner.CommitTimestamp},
+			))
+		} else {
+			creationTime = existingCreationTime
+		}
+		return txn.BufferWrite(append(mutations, spanner.InsertOrUpdate("idempotency_keys",
+			[]string{"tenant", "idempotency_key", "results", "creation_time", "expire_time"},
+			[]interface{}{tenant, key, data, creationTime, time.Now().Add(ms.idempotencyTTL)},
+		)))
+	})
+	ms.stats.reportStorageWrite(started, err)
+	if err != nil {
+		return nil, false, false, fmt.Errorf("cannot save model to Spanner: %v", err)
+	}
+	if replayedResults != nil {
+		replayedResults, err = replayIdempotentResults(replayedResults, results)
+		return replayedResults, err == nil, false, err
+	}
+
+	stored.CreationTime = commitTS.UTC()
+	if !existingCreationTime.IsZero() {
+		stored.CreationTime = existingCreationTime.UTC()
+	}
+	return &stored, false, existingCreationTime.IsZero(), nil
+}
+
+// storeTrainingResults stores the trained model, under the idempotency key if it is not empty, and commits the client's
+// quota reservation once a new model is stored; a replayed request or a model deduplicated by its content-addressed
+// name does not count against the quota. The storage errors are returned in the results; replayed is set if the results of a previous
+// request with the same idempotency key are returned instead, and errIdempotencyKeyReused is returned if the key
+// is used for different training data.
+func storeTrainingResults(ctx context.Context, ms *modelsStorage, reservation *quotaReservation, tenant string, key string, results *TrainingResults) (*TrainingResults, bool, error) {
+	if len(key) > 0 {
+		stored, replayed, created, err := ms.saveIdempotentSLRModel(ctx, tenant, key, results)
+		if err == errIdempotencyKeyReused {
+			return nil, false, err
+		}
+		if err != nil {
+			results.Error = fmt.Sprintf("%v", err)
+			return results, false, nil
+		}
+		if created {
+			reservation.commit()
+		}
+		return stored, replayed, nil
+	}
+
+	name, commitTime, created, err := ms.saveSLRModel(ctx, tenant, results.Model, results.DataFingerprint)
+	if err != nil {
+		results.Error = fmt.Sprintf("%v", err)
+	} else if created {
+		reservation.commit()
+	}
+	results.Name = name
+	results.CreationTime = commitTime
+	return results, false, nil
+}
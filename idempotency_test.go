@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	pb "linear_regression_service/github.com/ashagraev/linear_regression"
+)
+
+func TestCheckIdempotencyKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		wantErr bool
+	}{
+		{"", false},
+		{"nightly-2020-09-18", false},
+		{"key with spaces and ~", false},
+		{strings.Repeat("k", maxIdempotencyKeyLength), false},
+		{strings.Repeat("k", maxIdempotencyKeyLength + 1), true},
+		{"tab\tkey", true},
+		{"ключ", true},
+	}
+	for _, test := range tests {
+		if err := checkIdempotencyKey(test.key); (err != nil) != test.wantErr {
+			t.Errorf("checkIdempotencyKey(%q) = %v, want error %v", test.key, err, test.wantErr)
+		}
+	}
+}
+
+func TestReplayIdempotentResults(t *testing.T) {
+	stored := &TrainingResults{
+		Model:           &SimpleRegressionModel{Coefficient: 2, Intercept: 1},
+		Name:            "stored",
+		DataFingerprint: fingerprintOf([][3]float64{{1, 3, 1}, {2, 5, 1}}),
+	}
+
+	same := &TrainingResults{Model: &SimpleRegressionModel{Coefficient: 2, Intercept: 1}, DataFingerprint: stored.DataFingerprint}
+	if replayed, err := replayIdempotentResults(stored, same); err != nil || replayed != stored {
+		t.Errorf("request with the same data: replayed %v, %v; want the stored results", replayed, err)
+	}
+
+	different := &TrainingResults{Model: &SimpleRegressionModel{Coefficient: 2, Intercept: 1}, DataFingerprint: fingerprintOf([][3]float64{{1, 3, 1}})}
+	if replayed, err := replayIdempotentResults(stored, different); err != errIdempotencyKeyReused || replayed != nil {
+		t.Errorf("request with different data: replayed %v, %v; want %v", replayed, err, errIdempotencyKeyReused)
+	}
+}
+
+func TestTrainingResultsFromProto(t *testing.T) {
+	results := &TrainingResults{
+		Model:            &SimpleRegressionModel{Coefficient: 2, Intercept: 1},
+		SumSquaredErrors: 0.5,
+		Name:             "name",
+		CreationTime:     time.Date(2020, 9, 18, 10, 0, 0, 0, time.UTC),
+		DroppedInstances: 1,
+		ClampedInstances: 2,
+		InvalidInstances: []int{3, 7},
+		DataFingerprint:  fingerprintOf([][3]float64{{1, 3, 1}}),
+	}
+	decoded := trainingResultsFromProto(results.toProto().(*pb.TrainingResults))
+	decoded.Model.DataFingerprint = ""
+	if !reflect.DeepEqual(decoded, results) {
+		t.Errorf("decoded results %+v, want %+v", decoded, results)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// trainingInput is the training data file opened by the client, decompressed if needed.
+type trainingInput struct {
+	// name identifies the input in the error messages.
+	name   string
+	reader io.Reader
+
+	closers []func()
+}
+
+// openTrainingInput opens the training data file, stdin if the path is empty, and detects its compression by the magic bytes.
+func openTrainingInput(path string) (*trainingInput, error) {
+	input := &trainingInput{name: "stdin", reader: os.Stdin}
+	if len(path) > 0 {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open input: %v", err)
+		}
+		input.name = path
+		input.reader = file
+		input.closers = append(input.closers, func() { file.Close() })
+	}
+
+	buffered := bufio.NewReader(input.reader)
+	magic, _ := buffered.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			input.close()
+			return nil, fmt.Errorf("%v: cannot decompress gzip: %v", input.name, err)
+		}
+		input.reader = gzipReader
+		input.closers = append(input.closers, func() { gzipReader.Close() })
+	case bytes.HasPrefix(magic, zstdMagic):
+		zstdReader, err := zstd.NewReader(buffered)
+		if err != nil {
+			input.close()
+			return nil, fmt.Errorf("%v: cannot decompress zstd: %v", input.name, err)
+		}
+		input.reader = zstdReader
+		input.closers = append(input.closers, zstdReader.Close)
+	default:
+		input.reader = buffered
+	}
+	return input, nil
+}
+
+func (input *trainingInput) close() {
+	for idx := len(input.closers) - 1; idx >= 0; idx-- {
+		input.closers[idx]()
+	}
+}
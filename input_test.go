@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func gzipTestData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(data)
+	if err := writer.Close(); err != nil {
+		t.Fatalf("cannot compress gzip: %v", err)
+	}
+	return compressed.Bytes()
+}
+
+func zstdTestData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	writer, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("cannot create zstd writer: %v", err)
+	}
+	defer writer.Close()
+	return writer.EncodeAll(data, nil)
+}
+
+func TestOpenTrainingInput(t *testing.T) {
+	tsv := []byte("1\t3\n2\t5\n3\t7\n")
+	jsonLines := []byte("{\"x\": 1, \"y\": 3}\n{\"x\": 2, \"y\": 5, \"w\": 2}\n")
+
+	tests := []struct {
+		name          string
+		data          []byte
+		wantInstances int
+		wantErr       bool
+	}{
+		{"plain tsv", tsv, 3, false},
+		{"gzip tsv", gzipTestData(t, tsv), 3, false},
+		{"zstd tsv", zstdTestData(t, tsv), 3, false},
+		{"plain json lines", jsonLines, 2, false},
+		{"gzip json lines", gzipTestData(t, jsonLines), 2, false},
+		{"zstd json lines", zstdTestData(t, jsonLines), 2, false},
+		{"truncated gzip", gzipTestData(t, tsv)[:12], 0, true},
+		{"truncated zstd", zstdTestData(t, tsv)[:6], 0, true},
+	}
+	dir := t.TempDir()
+	for _, test := range tests {
+		path := filepath.Join(dir, "input")
+		if err := ioutil.WriteFile(path, test.data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		input, err := openTrainingInput(path)
+		if err == nil {
+			instances, loadErr := loadInstances(input.reader, &delimitedFormat{}, newInstanceValidator(rejectPolicy))
+			input.close()
+			if err = loadErr; err == nil && len(instances) != test.wantInstances {
+				t.Errorf("%v: loaded %v instances, want %v", test.name, len(instances), test.wantInstances)
+			}
+		}
+		if (err != nil) != test.wantErr {
+			t.Errorf("%v: error %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestOpenTrainingInputMissingFile(t *testing.T) {
+	if _, err := openTrainingInput(filepath.Join(t.TempDir(), "missing.tsv")); err == nil {
+		t.Errorf("openTrainingInput() of a missing file succeeded, want an error")
+	}
+}
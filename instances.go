@@ -2,70 +2,332 @@ package main
 
 import (
 	"bufio"
+	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"strconv"
 	"strings"
 
 	pb "linear_regression_service/github.com/ashagraev/linear_regression"
 )
 
-func loadInstancesFromTSV(reader io.Reader) ([][]float64, error){
-	var instances [][]float64
+// delimitedFormat describes the layout of delimited training data; the zero value stands for
+// whitespace-separated feature, target and optional weight columns.
+type delimitedFormat struct {
+	// Delimiter separates the columns; empty delimiter stands for any whitespace, \t for the tab.
+	// The comma-separated fields may be quoted as RFC 4180 describes.
+	Delimiter string `yaml:"delimiter"`
 
-	lineIdx := 0
+	// Header forces skipping the first line; otherwise it is skipped only if it is not numeric.
+	Header bool `yaml:"header"`
+
+	// Comment starts the lines to be skipped, e.g. #.
+	Comment string `yaml:"comment"`
+
+	// FeatureColumn, TargetColumn and WeightColumn choose the columns by zero-based index or by the header name.
+	// The first two columns are used by default, the instances are not weighted unless WeightColumn is chosen.
+	FeatureColumn string `yaml:"feature_column"`
+	TargetColumn  string `yaml:"target_column"`
+	WeightColumn  string `yaml:"weight_column"`
+}
+
+// instanceColumns stores the indices of the columns an instance is read from; weight is -1 if it is not chosen.
+type instanceColumns struct {
+	feature int
+	target  int
+	weight  int
+
+	// legacy columns are the feature, the target and the optional weight, nothing else.
+	legacy bool
+}
+
+func (f *delimitedFormat) delimiter() string {
+	if f.Delimiter == `\t` {
+		return "\t"
+	}
+	return f.Delimiter
+}
+
+// columnsByName checks if the columns are chosen by name, so the header is required.
+func (f *delimitedFormat) columnsByName() bool {
+	for _, column := range []string{f.FeatureColumn, f.TargetColumn, f.WeightColumn} {
+		if _, err := strconv.Atoi(column); len(column) > 0 && err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveColumn(column string, header []string) (int, error) {
+	if idx, err := strconv.Atoi(column); err == nil {
+		if idx < 0 {
+			return 0, fmt.Errorf("negative column index: %v", idx)
+		}
+		return idx, nil
+	}
+	for idx, name := range header {
+		if strings.Trim(name, `"`) == column {
+			return idx, nil
+		}
+	}
+	return 0, fmt.Errorf("column %q is not found", column)
+}
+
+func (f *delimitedFormat) resolveColumns(header []string) (*instanceColumns, error) {
+	if len(f.FeatureColumn) == 0 && len(f.TargetColumn) == 0 && len(f.WeightColumn) == 0 {
+		return &instanceColumns{feature: 0, target: 1, weight: 2, legacy: true}, nil
+	}
+
+	columns := &instanceColumns{feature: 0, target: 1, weight: -1}
+	var err error
+	if len(f.FeatureColumn) > 0 {
+		if columns.feature, err = resolveColumn(f.FeatureColumn, header); err != nil {
+			return nil, err
+		}
+	}
+	if len(f.TargetColumn) > 0 {
+		if columns.target, err = resolveColumn(f.TargetColumn, header); err != nil {
+			return nil, err
+		}
+	}
+	if len(f.WeightColumn) > 0 {
+		if columns.weight, err = resolveColumn(f.WeightColumn, header); err != nil {
+			return nil, err
+		}
+	}
+	return columns, nil
+}
+
+// isComment checks if the line or the record's first field is a comment.
+func (f *delimitedFormat) isComment(line string) bool {
+	return len(f.Comment) > 0 && strings.HasPrefix(strings.TrimSpace(line), f.Comment)
+}
+
+// recordReader returns the fields of the next record that is neither empty nor a comment with its line number,
+// or io.EOF at the end of the data.
+type recordReader func() ([]string, int, error)
+
+func newRecordReader(reader io.Reader, format *delimitedFormat) recordReader {
+	if format.delimiter() == "," {
+		return newCSVRecordReader(reader, format)
+	}
+
+	delimiter := format.delimiter()
 	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		var instance []float64
-		for _, s := range strings.Fields(scanner.Text()) {
-			v, err := strconv.ParseFloat(s, 64)
+	lineIdx := 0
+	return func() ([]string, int, error) {
+		for scanner.Scan() {
+			lineIdx++
+			line := scanner.Text()
+			if format.isComment(line) {
+				continue
+			}
+			if tokens := splitInstanceLine(line, delimiter); len(tokens) > 0 {
+				return tokens, lineIdx, nil
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, lineIdx, fmt.Errorf("cannot read instances: %w", err)
+		}
+		return nil, lineIdx, io.EOF
+	}
+}
+
+// newCSVRecordReader reads the comma-separated values with encoding/csv, so that the quoted fields are unquoted.
+func newCSVRecordReader(reader io.Reader, format *delimitedFormat) recordReader {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+	return func() ([]string, int, error) {
+		for {
+			record, err := csvReader.Read()
+			if err == io.EOF {
+				return nil, 0, err
+			}
 			if err != nil {
-				return nil, fmt.Errorf("invalid float: %v", err)
+				return nil, 0, fmt.Errorf("cannot read instances: %w", err)
+			}
+			lineIdx, _ := csvReader.FieldPos(0)
+			if format.isComment(record[0]) {
+				continue
+			}
+			for idx := range record {
+				record[idx] = strings.TrimSpace(record[idx])
+			}
+			if len(record) == 1 && len(record[0]) == 0 {
+				continue
 			}
-			instance = append(instance, v)
+			return record, lineIdx, nil
 		}
-		if len(instance) == 0 {
+	}
+}
+
+// splitInstanceLine splits the line by the delimiter; empty delimiter stands for any whitespace.
+func splitInstanceLine(line string, delimiter string) []string {
+	if len(delimiter) == 0 {
+		return strings.Fields(line)
+	}
+	if len(strings.TrimSpace(line)) == 0 {
+		return nil
+	}
+	tokens := strings.Split(line, delimiter)
+	for idx := range tokens {
+		tokens[idx] = strings.TrimSpace(tokens[idx])
+	}
+	return tokens
+}
+
+func parseFloatToken(tokens []string, column int) (float64, error) {
+	if column >= len(tokens) {
+		return 0, fmt.Errorf("no column %v", column)
+	}
+	v, err := strconv.ParseFloat(tokens[column], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid float: %w", err)
+	}
+	return v, nil
+}
+
+// isHeader checks if the line is a header: none of its chosen columns is a number, even an out of range one.
+func (c *instanceColumns) isHeader(tokens []string) bool {
+	chosen := 0
+	for _, column := range []int{c.feature, c.target, c.weight} {
+		if column < 0 || column >= len(tokens) {
 			continue
 		}
-		if len(instance) != 2 {
-			return nil, fmt.Errorf("bad number of tokens: %v, line %v", len(instance), lineIdx)
+		chosen++
+		_, err := strconv.ParseFloat(tokens[column], 64)
+		if numErr, ok := err.(*strconv.NumError); err == nil || ok && numErr.Err == strconv.ErrRange {
+			return false
 		}
+	}
+	return chosen > 0
+}
 
-		instances = append(instances, instance)
-		lineIdx++
+// parseInstance reads the feature, the target and the weight, if any, from the chosen columns.
+func (c *instanceColumns) parseInstance(tokens []string) ([]float64, error) {
+	if c.legacy && len(tokens) != 2 && len(tokens) != 3 {
+		return nil, fmt.Errorf("bad number of tokens: %v", len(tokens))
 	}
 
-	if len(instances) == 0 {
-		return nil, errors.New("no instances loaded")
+	weightColumn := c.weight
+	if c.legacy && len(tokens) == 2 {
+		weightColumn = -1
 	}
 
-	return instances, nil
+	instance := make([]float64, 0, 3)
+	for _, column := range []int{c.feature, c.target, weightColumn} {
+		if column < 0 {
+			continue
+		}
+		v, err := parseFloatToken(tokens, column)
+		if err != nil {
+			return nil, err
+		}
+		instance = append(instance, v)
+	}
+	return instance, nil
 }
 
-func loadProtoInstancesFromTSV(reader io.Reader) ([]*pb.Instance, error){
-	var instances []*pb.Instance
+// readDelimitedInstances reads the instances from a delimited text stream, one instance per line,
+// and passes them to consume one by one as the feature, the target and the optional weight with their line numbers.
+// Empty and comment lines are skipped; the first line is treated as a header if the format requires it,
+// or if the columns are chosen by name, or if none of its chosen columns is numeric.
+func readDelimitedInstances(reader io.Reader, format *delimitedFormat, consume func(position int, instance []float64) error) (int, error) {
+	count := 0
+	headerRequired := format.Header || format.columnsByName()
 
-	lineIdx := 0
-	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		var instance []float64
-		for _, s := range strings.Fields(scanner.Text()) {
-			v, err := strconv.ParseFloat(s, 64)
-			if err != nil {
-				return nil, fmt.Errorf("invalid float: %v", err)
+	var columns *instanceColumns
+	firstLine := true
+	records := newRecordReader(reader, format)
+	for {
+		tokens, lineIdx, err := records()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+
+		if columns == nil {
+			var header []string
+			if headerRequired {
+				header = tokens
+			}
+			if columns, err = format.resolveColumns(header); err != nil {
+				return count, err
+			}
+			if headerRequired {
+				continue
 			}
-			instance = append(instance, v)
 		}
-		if len(instance) == 0 {
+
+		instance, err := columns.parseInstance(tokens)
+		if err != nil && firstLine && !headerRequired && columns.isHeader(tokens) {
+			firstLine = false
 			continue
 		}
-		if len(instance) != 2 {
-			return nil, fmt.Errorf("bad number of tokens: %v, line %v", len(instance), lineIdx)
+		firstLine = false
+		if err != nil {
+			return count, fmt.Errorf("%v, line %v", err, lineIdx)
+		}
+
+		if err := consume(lineIdx, instance); err != nil {
+			return count, err
 		}
+		count++
+	}
+
+	return count, nil
+}
 
-		instances = append(instances, &pb.Instance{Argument: instance[0], Target: instance[1], Weight: 1})
-		lineIdx++
+// columnarInput rewinds the regular files and passes them to the columnar readers as is, so that they are not spooled;
+// other inputs are read from the buffered reader their magic bytes were peeked from.
+func columnarInput(reader io.Reader, buffered *bufio.Reader) io.Reader {
+	file, ok := reader.(*os.File)
+	if !ok {
+		return buffered
+	}
+	if info, err := file.Stat(); err != nil || !info.Mode().IsRegular() {
+		return buffered
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return buffered
+	}
+	return file
+}
+
+// loadInstances loads the training data file, dropping or clamping the invalid instances with the validator.
+// Parquet and Arrow IPC files are detected by their magic bytes and JSON Lines records by the leading brace;
+// delimited values are read according to the format otherwise.
+func loadInstances(reader io.Reader, format *delimitedFormat, validator *instanceValidator) ([]*pb.Instance, error){
+	var instances []*pb.Instance
+	consume := func(position int, instance []float64) error {
+		weight := 1.0
+		if len(instance) == 3 {
+			weight = instance[2]
+		}
+		weight, ok, err := validator.check(position, instance[0], instance[1], weight)
+		if ok {
+			instances = append(instances, &pb.Instance{Argument: instance[0], Target: instance[1], Weight: weight})
+		}
+		return err
+	}
+
+	buffered := bufio.NewReader(reader)
+	magic, _ := buffered.Peek(len(arrowFileMagic))
+	var err error
+	if kind, ok := detectColumnarKind(magic); ok {
+		_, err = readColumnarInstances(kind, columnarInput(reader, buffered), "", format, consume)
+	} else if isJSONLines(buffered) {
+		_, err = decodeJSONLinesInstances(buffered, consume)
+	} else {
+		_, err = readDelimitedInstances(buffered, format, consume)
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	if len(instances) == 0 {
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"unicode"
+)
+
+// errUnsupportedMediaType is returned for the training data of unknown content type.
+var errUnsupportedMediaType = errors.New("unsupported content type, use application/json, text/tab-separated-values, text/csv, " +
+	"application/vnd.apache.parquet, application/vnd.apache.arrow.file or application/vnd.apache.arrow.stream")
+
+// decodeJSONInstances streams the JSON array of instances ([[x, y], [x, y, w], ...]) from the reader
+// and passes them to consume one by one with their indices, so that the whole array is never kept in memory.
+// The instance slice passed to consume is reused between the calls.
+func decodeJSONInstances(reader io.Reader, consume func(position int, instance []float64) error) (int, error) {
+	decoder := json.NewDecoder(reader)
+
+	token, err := decoder.Token()
+	if err != nil {
+		return 0, fmt.Errorf("could not load json: %w", err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return 0, fmt.Errorf("could not load json: instances array expected")
+	}
+
+	count := 0
+	var instance []float64
+	for decoder.More() {
+		instance = instance[:0]
+		if err := decoder.Decode(&instance); err != nil {
+			return count, fmt.Errorf("could not load json instance #%v: %w", count, err)
+		}
+		if err := consume(count, instance); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return count, fmt.Errorf("could not load json: %w", err)
+	}
+	return count, nil
+}
+
+// jsonLinesInstance is a single JSON Lines record: {"x": 1, "y": 2, "w": 0.5}; the weight is optional.
+type jsonLinesInstance struct {
+	X *float64 `json:"x"`
+	Y *float64 `json:"y"`
+	W *float64 `json:"w"`
+}
+
+// decodeJSONLinesInstances reads the JSON Lines records from the reader and passes them to consume one by one
+// with their line numbers.
+func decodeJSONLinesInstances(reader io.Reader, consume func(position int, instance []float64) error) (int, error) {
+	count := 0
+	lineIdx := 0
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		lineIdx++
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var record jsonLinesInstance
+		if err := json.Unmarshal(line, &record); err != nil {
+			return count, fmt.Errorf("could not load json record: %v, line %v", err, lineIdx)
+		}
+		if record.X == nil || record.Y == nil {
+			return count, fmt.Errorf("json record must contain x and y, line %v", lineIdx)
+		}
+		instance := []float64{*record.X, *record.Y}
+		if record.W != nil {
+			instance = append(instance, *record.W)
+		}
+
+		if err := consume(lineIdx, instance); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return count, fmt.Errorf("cannot read instances: %w", err)
+	}
+
+	return count, nil
+}
+
+// isJSONLines checks if the first non-space character of the stream opens a JSON object.
+func isJSONLines(reader *bufio.Reader) bool {
+	for n := 1; n <= reader.Size(); n++ {
+		data, _ := reader.Peek(n)
+		if len(data) < n {
+			return false
+		}
+		if c := data[n - 1]; !unicode.IsSpace(rune(c)) {
+			return c == '{'
+		}
+	}
+	return false
+}
+
+// trainingMediaType returns the media type of the training data, application/json if the content type is empty.
+func trainingMediaType(contentType string) (string, error) {
+	if len(contentType) == 0 {
+		return "application/json", nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", errUnsupportedMediaType
+	}
+	switch mediaType {
+	case "application/json", "text/tab-separated-values", "text/csv", "application/vnd.apache.parquet",
+		"application/vnd.apache.arrow.file", "application/vnd.apache.arrow.stream":
+		return mediaType, nil
+	}
+	return "", errUnsupportedMediaType
+}
+
+// decodeTrainingInstances chooses the training data format by the request's content type:
+// JSON arrays by default, tab-separated or comma-separated values for text/tab-separated-values and text/csv,
+// Parquet and Arrow IPC files for application/vnd.apache.parquet, application/vnd.apache.arrow.file
+// and application/vnd.apache.arrow.stream. The format chooses the columns of the delimited and columnar data;
+// the uploaded Parquet and Arrow files are spooled to the spool directory unless the reader is a file already.
+func decodeTrainingInstances(contentType string, format delimitedFormat, reader io.Reader, spoolDir string, consume func(position int, instance []float64) error) (int, error) {
+	mediaType, err := trainingMediaType(contentType)
+	if err != nil {
+		return 0, err
+	}
+
+	switch mediaType {
+	case "application/json":
+		return decodeJSONInstances(reader, consume)
+	case "text/tab-separated-values":
+		format.Delimiter = "\t"
+		return readDelimitedInstances(reader, &format, consume)
+	case "text/csv":
+		format.Delimiter = ","
+		return readDelimitedInstances(reader, &format, consume)
+	case "application/vnd.apache.parquet":
+		return readColumnarInstances(parquetKind, reader, spoolDir, &format, consume)
+	case "application/vnd.apache.arrow.file":
+		return readColumnarInstances(arrowFileKind, reader, spoolDir, &format, consume)
+	case "application/vnd.apache.arrow.stream":
+		return readColumnarInstances(arrowStreamKind, reader, spoolDir, &format, consume)
+	}
+	return 0, errUnsupportedMediaType
+}
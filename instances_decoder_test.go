@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decodeInstances(contentType string, data string) ([][]float64, []int, error) {
+	var instances [][]float64
+	var positions []int
+	_, err := decodeTrainingInstances(contentType, delimitedFormat{}, strings.NewReader(data), "", func(position int, instance []float64) error {
+		instances = append(instances, append([]float64(nil), instance...))
+		positions = append(positions, position)
+		return nil
+	})
+	return instances, positions, err
+}
+
+func TestDecodeTrainingInstances(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentType   string
+		data          string
+		want          [][]float64
+		wantPositions []int
+	}{
+		{"json", "application/json", "[[1, 2], [3, 4, 0.5]]", [][]float64{{1, 2}, {3, 4, 0.5}}, []int{0, 1}},
+		{"tsv", "text/tab-separated-values", "x\ty\n1\t2\n\n3\t4\t0.5\n", [][]float64{{1, 2}, {3, 4, 0.5}}, []int{2, 4}},
+		{"tsv with spaces", "text/tab-separated-values; charset=utf-8", " 1 \t 2 \n", [][]float64{{1, 2}}, []int{1}},
+		{"csv", "text/csv", "x,y\n1,2\n3, 4,0.5\n", [][]float64{{1, 2}, {3, 4, 0.5}}, []int{2, 3}},
+		{"quoted csv", "text/csv", "\"x\",\"y\"\n\"1\",2\n\"3\", \"4\"\n", [][]float64{{1, 2}, {3, 4}}, []int{2, 3}},
+		{"multiline quoted csv header", "text/csv", "\"x\ncolumn\",y\n1,2\n", [][]float64{{1, 2}}, []int{3}},
+	}
+	for _, test := range tests {
+		instances, positions, err := decodeInstances(test.contentType, test.data)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(instances, test.want) || !reflect.DeepEqual(positions, test.wantPositions) {
+			t.Errorf("%v: instances %v at %v, want %v at %v", test.name, instances, positions, test.want, test.wantPositions)
+		}
+	}
+}
+
+func TestDecodeTrainingInstancesErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		data        string
+		wantErr     string
+	}{
+		{"empty tsv cell", "text/tab-separated-values", "1\t2\n1\t2\t\n", "line 2"},
+		{"space-separated tsv", "text/tab-separated-values", "1\t2\n3 4\n", "line 2"},
+		{"unterminated csv quote", "text/csv", "1,2\n\"3,4\n", "cannot read instances"},
+		{"json object", "application/json", `{"x": 1}`, "instances array expected"},
+		{"unsupported", "text/plain", "1 2\n", "unsupported content type"},
+	}
+	for _, test := range tests {
+		_, _, err := decodeInstances(test.contentType, test.data)
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%v: error %v, want one containing %q", test.name, err, test.wantErr)
+		}
+	}
+}
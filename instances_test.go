@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readDelimited(t *testing.T, data string, format delimitedFormat) ([][]float64, error) {
+	t.Helper()
+	var instances [][]float64
+	count, err := readDelimitedInstances(strings.NewReader(data), &format, func(position int, instance []float64) error {
+		instances = append(instances, instance)
+		return nil
+	})
+	if count != len(instances) {
+		t.Errorf("readDelimitedInstances() counted %v instances, consumed %v", count, len(instances))
+	}
+	return instances, err
+}
+
+func TestReadDelimitedInstances(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		format delimitedFormat
+		want   [][]float64
+	}{
+		{"whitespace", "1 2\n\n3\t4 0.5\n", delimitedFormat{}, [][]float64{{1, 2}, {3, 4, 0.5}}},
+		{"csv", "1,2\n3, 4\n", delimitedFormat{Delimiter: ","}, [][]float64{{1, 2}, {3, 4}}},
+		{"comments", "# x y\n1 2\n  # skipped\n3 4\n", delimitedFormat{Comment: "#"}, [][]float64{{1, 2}, {3, 4}}},
+		{"non-numeric header", "x,y\n1,2\n", delimitedFormat{Delimiter: ","}, [][]float64{{1, 2}}},
+		{"header with numeric unchosen columns", "1,x,y\n0,1,2\n", delimitedFormat{Delimiter: ",", FeatureColumn: "1", TargetColumn: "2"}, [][]float64{{1, 2}}},
+		{"forced header", "1,2\n3,4\n", delimitedFormat{Delimiter: ",", Header: true}, [][]float64{{3, 4}}},
+		{"columns by index", "9,1,2\n9,3,4\n", delimitedFormat{Delimiter: ",", FeatureColumn: "1", TargetColumn: "2"},
+			[][]float64{{1, 2}, {3, 4}}},
+		{"columns by name", "w,\"y\",x\n0.5,2,1\n1,4,3\n", delimitedFormat{Delimiter: ",", FeatureColumn: "x", TargetColumn: "y", WeightColumn: "w"},
+			[][]float64{{1, 2, 0.5}, {3, 4, 1}}},
+		{"same feature and target column", "x,y\n1,2\n", delimitedFormat{Delimiter: ",", FeatureColumn: "x", TargetColumn: "x"},
+			[][]float64{{1, 1}}},
+	}
+	for _, test := range tests {
+		instances, err := readDelimited(t, test.data, test.format)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(instances, test.want) {
+			t.Errorf("%v: instances %v, want %v", test.name, instances, test.want)
+		}
+	}
+}
+
+func TestReadDelimitedInstancesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		format  delimitedFormat
+		wantErr string
+	}{
+		{"non-numeric line", "1,2\nx,y\n", delimitedFormat{Delimiter: ","}, "line 2"},
+		{"second non-numeric line", "x,y\na,b\n", delimitedFormat{Delimiter: ","}, "line 2"},
+		{"bad number of tokens in the first line", "1,2,3,4\n1,2\n", delimitedFormat{Delimiter: ","}, "line 1"},
+		{"partially numeric first line", "1 abc\n2 3\n", delimitedFormat{}, "line 1"},
+		{"partially numeric first line in chosen columns", "x,1,abc\n0,2,3\n", delimitedFormat{Delimiter: ",", FeatureColumn: "1", TargetColumn: "2"}, "line 1"},
+		{"out of range first line", "1e999,2\n", delimitedFormat{Delimiter: ","}, "line 1"},
+		{"missing column", "1,2\n3\n", delimitedFormat{Delimiter: ",", FeatureColumn: "0", TargetColumn: "1"}, "line 2"},
+		{"unknown column name", "x,y\n1,2\n", delimitedFormat{Delimiter: ",", FeatureColumn: "z"}, `column "z" is not found`},
+		{"negative column index", "1,2\n", delimitedFormat{Delimiter: ",", FeatureColumn: "-1"}, "negative column index"},
+	}
+	for _, test := range tests {
+		_, err := readDelimited(t, test.data, test.format)
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%v: error %v, want one containing %q", test.name, err, test.wantErr)
+		}
+	}
+}
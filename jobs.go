@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "linear_regression_service/github.com/ashagraev/linear_regression"
+)
+
+var (
+	errJobNotFound  = errors.New("job is not found")
+	errJobCancelled = errors.New("job is cancelled")
+	errJobsStopped  = errors.New("service is shutting down")
+	errJobTimeout   = errors.New("job processing time limit is exceeded")
+)
+
+// TrainingJob stores the state of an asynchronous training job.
+type TrainingJob struct {
+	ID    string
+	State pb.TrainingJob_State
+
+	// ProcessedInstances counts the instances trained on so far; TotalInstances is zero while it is unknown.
+	ProcessedInstances int
+	TotalInstances     int
+
+	// Results are set for the succeeded jobs and for the jobs failed to store the trained model, Error for the failed jobs.
+	Results *TrainingResults
+	Error   string
+
+	CreateTime time.Time
+	StartTime  time.Time
+	FinishTime time.Time
+}
+
+func (job *TrainingJob) toProto() proto.Message {
+	result := &pb.TrainingJob{
+		Id:                 job.ID,
+		State:              job.State,
+		ProcessedInstances: int64(job.ProcessedInstances),
+		TotalInstances:     int64(job.TotalInstances),
+		Error:              job.Error,
+		CreateTime:         timestampProto(job.CreateTime),
+		StartTime:          timestampProto(job.StartTime),
+		FinishTime:         timestampProto(job.FinishTime),
+	}
+	if job.Results != nil {
+		result.Results = job.Results.toProto().(*pb.TrainingResults)
+	}
+	return result
+}
+
+// trainingJob is a job queued or processed by the jobs manager.
+type trainingJob struct {
+	mutex  sync.Mutex
+	status TrainingJob
+
+	tenant string
+	source trainingSource
+
+	// reservation holds the client's quota for the job's model; it is released unless the model is stored.
+	reservation *quotaReservation
+
+	// idempotencyKey stores the model under the key, see storeTrainingResults.
+	idempotencyKey string
+
+	// cleanup releases the resources of the source, e.g. removes the spooled training data.
+	cleanup func()
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+}
+
+// snapshot copies the job's status for the responses.
+func (job *trainingJob) snapshot() *TrainingJob {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	status := job.status
+	return &status
+}
+
+// start marks the queued job running; it returns false if the job is already finished.
+func (job *trainingJob) start() bool {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	if job.status.State != pb.TrainingJob_QUEUED {
+		return false
+	}
+	job.status.State = pb.TrainingJob_RUNNING
+	job.status.StartTime = time.Now().UTC()
+	return true
+}
+
+func (job *trainingJob) reportProgress(processedInstances int) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	job.status.ProcessedInstances = processedInstances
+}
+
+// finish records the outcome of the job in one of the given states; it returns false if the job is in another state.
+func (job *trainingJob) finish(state pb.TrainingJob_State, results *TrainingResults, err error, from ...pb.TrainingJob_State) bool {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	finishing := false
+	for _, fromState := range from {
+		finishing = finishing || job.status.State == fromState
+	}
+	if !finishing {
+		return false
+	}
+	job.status.State = state
+	job.status.Results = results
+	if err != nil {
+		job.status.Error = err.Error()
+	}
+	job.status.FinishTime = time.Now().UTC()
+	return true
+}
+
+// jobsManager trains the models of the submitted jobs in a bounded pool of workers and keeps the jobs
+// for polling until the retention time passes. The jobs are kept in memory and are lost on restart.
+type jobsManager struct {
+	config        *handlerConfig
+	modelsStorage *modelsStorage
+
+	queue chan *trainingJob
+
+	mutex sync.Mutex
+	jobs  map[string]*trainingJob
+
+	// closing stops the workers taking the queued jobs on shutdown; ctx is the parent of the jobs' contexts,
+	// it is cancelled if the running jobs do not finish before the shutdown deadline.
+	closing chan struct{}
+	ctx     context.Context
+	stop    context.CancelCauseFunc
+	workers sync.WaitGroup
+}
+
+func newJobsManager(config *handlerConfig, modelsStorage *modelsStorage) *jobsManager {
+	ctx, stop := context.WithCancelCause(context.Background())
+	jm := &jobsManager{
+		config:        config,
+		modelsStorage: modelsStorage,
+		queue:         make(chan *trainingJob, config.Jobs.QueueSize),
+		jobs:          map[string]*trainingJob{},
+		closing:       make(chan struct{}),
+		ctx:           ctx,
+		stop:          stop,
+	}
+	for i := 0; i < config.Jobs.Workers; i++ {
+		jm.workers.Add(1)
+		go jm.work()
+	}
+	return jm
+}
+
+func randomJobID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("cannot create random job id: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// submit queues the job training the tenant's model on the source and storing it under the idempotency key, if it is not empty;
+// totalInstances is zero if unknown. The cleanup is called and the quota reservation is released or committed once the job
+// is finished, unless submit fails.
+func (jm *jobsManager) submit(tenant string, reservation *quotaReservation, idempotencyKey string, totalInstances int, source trainingSource, cleanup func()) (*TrainingJob, error) {
+	id, err := randomJobID()
+	if err != nil {
+		return nil, err
+	}
+	job := &trainingJob{
+		status: TrainingJob{
+			ID:             id,
+			State:          pb.TrainingJob_QUEUED,
+			TotalInstances: totalInstances,
+			CreateTime:     time.Now().UTC(),
+		},
+		tenant:         tenant,
+		source:         source,
+		reservation:    reservation,
+		idempotencyKey: idempotencyKey,
+		cleanup:        cleanup,
+	}
+	job.ctx, job.cancel = context.WithCancelCause(jm.ctx)
+
+	jm.mutex.Lock()
+	defer jm.mutex.Unlock()
+	select {
+	case <-jm.closing:
+		return nil, errJobsStopped
+	default:
+	}
+	select {
+	case jm.queue <- job:
+	default:
+		return nil, &quotaError{fmt.Sprintf("training jobs queue is full: %v jobs are waiting", jm.config.Jobs.QueueSize)}
+	}
+	jm.jobs[id] = job
+	return job.snapshot(), nil
+}
+
+// find returns the tenant's job; the jobs of the other tenants are not found.
+func (jm *jobsManager) find(tenant, id string) (*trainingJob, error) {
+	jm.mutex.Lock()
+	defer jm.mutex.Unlock()
+	job, ok := jm.jobs[id]
+	if !ok || job.tenant != tenant {
+		return nil, errJobNotFound
+	}
+	return job, nil
+}
+
+func (jm *jobsManager) get(tenant, id string) (*TrainingJob, error) {
+	job, err := jm.find(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	return job.snapshot(), nil
+}
+
+// cancel stops the queued or running job; the finished jobs are left as is. A queued job is cancelled at once,
+// a running one stays running until its worker notices the cancellation before the next instance or before storing the model.
+func (jm *jobsManager) cancel(tenant, id string) (*TrainingJob, error) {
+	job, err := jm.find(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	job.cancel(errJobCancelled)
+	if job.finish(pb.TrainingJob_CANCELLED, nil, errJobCancelled, pb.TrainingJob_QUEUED) {
+		job.reservation.release()
+		jm.expire(job)
+	}
+	return job.snapshot(), nil
+}
+
+// expire forgets the finished job after the retention time.
+func (jm *jobsManager) expire(job *trainingJob) {
+	time.AfterFunc(jm.config.Jobs.Retention, func() {
+		jm.mutex.Lock()
+		defer jm.mutex.Unlock()
+		delete(jm.jobs, job.status.ID)
+	})
+}
+
+func (jm *jobsManager) work() {
+	defer jm.workers.Done()
+	for {
+		select {
+		case job := <-jm.queue:
+			jm.process(job)
+		case <-jm.closing:
+			return
+		}
+	}
+}
+
+func (jm *jobsManager) process(job *trainingJob) {
+	defer job.cleanup()
+	defer job.reservation.release()
+	defer job.cancel(nil)
+	if !job.start() {
+		return
+	}
+
+	ctx := job.ctx
+	if jm.config.Jobs.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, jm.config.Jobs.Timeout, errJobTimeout)
+		defer cancel()
+	}
+
+	results, err := jm.train(ctx, job)
+	if err == nil && len(results.Error) > 0 {
+		err = errors.New(results.Error)
+	}
+	state := pb.TrainingJob_SUCCEEDED
+	switch {
+	case err != nil && context.Cause(ctx) == errJobCancelled:
+		state, results, err = pb.TrainingJob_CANCELLED, nil, errJobCancelled
+	case err != nil && ctx.Err() != nil:
+		state, results, err = pb.TrainingJob_FAILED, nil, context.Cause(ctx)
+	case err != nil:
+		state = pb.TrainingJob_FAILED
+	}
+	if job.finish(state, results, err, pb.TrainingJob_RUNNING) {
+		jm.expire(job)
+	}
+}
+
+// train trains and stores the job's model; the storage errors are returned in the training results.
+// The job with the idempotency key used before succeeds with the stored results once its data is checked,
+// see replayIdempotentResults.
+func (jm *jobsManager) train(ctx context.Context, job *trainingJob) (*TrainingResults, error) {
+	var storedResults *TrainingResults
+	if len(job.idempotencyKey) > 0 {
+		var err error
+		if storedResults, err = jm.modelsStorage.findIdempotentResults(ctx, job.tenant, job.idempotencyKey); err != nil {
+			return nil, err
+		}
+	}
+
+	results, err := trainInstances(func(consume func(position int, instance []float64) error) (int, error) {
+		readCount := 0
+		instancesCount, err := job.source(func(position int, instance []float64) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			readCount++
+			if err := checkInstancesLimit(jm.config, readCount); err != nil {
+				return err
+			}
+			if readCount % 1024 == 0 {
+				job.reportProgress(readCount)
+			}
+			return consume(position, instance)
+		})
+		job.reportProgress(instancesCount)
+		return instancesCount, err
+	}, newInstanceValidator(jm.config.InvalidInstances))
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if storedResults != nil {
+		return replayIdempotentResults(storedResults, results)
+	}
+	results, _, err = storeTrainingResults(ctx, jm.modelsStorage, job.reservation, job.tenant, job.idempotencyKey, results)
+	return results, err
+}
+
+// close stops accepting the jobs and fails the queued ones, lets the running jobs finish until the deadline,
+// cancels the jobs still running after it and waits for the workers to stop.
+func (jm *jobsManager) close(deadline time.Time) {
+	jm.mutex.Lock()
+	close(jm.closing)
+	jm.mutex.Unlock()
+
+	for drained := false; !drained; {
+		select {
+		case job := <-jm.queue:
+			job.finish(pb.TrainingJob_FAILED, nil, errJobsStopped, pb.TrainingJob_QUEUED)
+			job.reservation.release()
+			job.cleanup()
+		default:
+			drained = true
+		}
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		jm.workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Until(deadline)):
+		log.Printf("could not finish running training jobs in time, cancelling them")
+		jm.stop(errJobsStopped)
+		<-stopped
+	}
+	jm.stop(nil)
+}
+
+// spoolTrainingData saves the uploaded training data to a temporary file, so that the job is trained on it after the response.
+func spoolTrainingData(dir string, reader io.Reader) (*os.File, error) {
+	file, err := os.CreateTemp(dir, "training-job-*")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create spool file: %v", err)
+	}
+	if _, err := io.Copy(file, reader); err != nil {
+		removeSpoolFile(file)
+		return nil, err
+	}
+	return file, nil
+}
+
+func removeSpoolFile(file *os.File) {
+	file.Close()
+	if err := os.Remove(file.Name()); err != nil {
+		log.Printf("could not remove spool file: %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "linear_regression_service/github.com/ashagraev/linear_regression"
+)
+
+func newTestJobsManager(workers int, queueSize int, retention time.Duration) *jobsManager {
+	config := defaultServiceConfig().Handler
+	config.Jobs = jobsConfig{Workers: workers, QueueSize: queueSize, Retention: retention}
+	return newJobsManager(&config, nil)
+}
+
+// failingSource fails the training without touching the storage.
+func failingSource(consume func(position int, instance []float64) error) (int, error) {
+	return 0, errors.New("broken training data")
+}
+
+// blockingSource passes instances to consume until it fails, reporting the start and waiting for next between them.
+func blockingSource(started chan<- struct{}, next <-chan struct{}) trainingSource {
+	return func(consume func(position int, instance []float64) error) (int, error) {
+		close(started)
+		for position := 0; ; position++ {
+			if err := consume(position, []float64{float64(position), 1}); err != nil {
+				return position, err
+			}
+			<-next
+		}
+	}
+}
+
+func waitForJob(t *testing.T, jm *jobsManager, tenant string, id string, done func(job *TrainingJob, err error) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := jm.get(tenant, id)
+		if done(job, err) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %v: %+v, %v; gave up waiting", id, job, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func waitForJobState(t *testing.T, jm *jobsManager, id string, state pb.TrainingJob_State) *TrainingJob {
+	t.Helper()
+	var finished *TrainingJob
+	waitForJob(t, jm, "acme", id, func(job *TrainingJob, err error) bool {
+		finished = job
+		return err == nil && job.State == state
+	})
+	return finished
+}
+
+func TestJobsManagerCancel(t *testing.T) {
+	tests := []struct {
+		name      string
+		workers   int
+		run       bool
+		wantState pb.TrainingJob_State
+	}{
+		{"queued job", 0, false, pb.TrainingJob_CANCELLED},
+		{"running job", 1, true, pb.TrainingJob_CANCELLED},
+		{"failed job", 1, false, pb.TrainingJob_FAILED},
+	}
+	for _, test := range tests {
+		jm := newTestJobsManager(test.workers, 10, time.Hour)
+		limiter := newClientLimiter(&limitsConfig{ModelsPerDay: 1})
+		reservation, _ := limiter.reserveStoredModel("key:pipelines")
+
+		var cleanups int32
+		cleanup := func() {
+			atomic.AddInt32(&cleanups, 1)
+		}
+		started, next := make(chan struct{}), make(chan struct{})
+		source := trainingSource(failingSource)
+		if test.run {
+			source = blockingSource(started, next)
+		}
+		job, err := jm.submit("acme", reservation, "", 0, source, cleanup)
+		if err != nil {
+			t.Fatalf("%v: submit() error: %v", test.name, err)
+		}
+		if test.run {
+			<-started
+		} else if test.workers > 0 {
+			waitForJobState(t, jm, job.ID, pb.TrainingJob_FAILED)
+		}
+
+		if _, err := jm.cancel("other", job.ID); err != errJobNotFound {
+			t.Errorf("%v: other tenant's cancel() = %v, want %v", test.name, err, errJobNotFound)
+		}
+		if _, err := jm.cancel("acme", job.ID); err != nil {
+			t.Fatalf("%v: cancel() error: %v", test.name, err)
+		}
+		if test.run {
+			close(next)
+		}
+		finished := waitForJobState(t, jm, job.ID, test.wantState)
+		if test.wantState == pb.TrainingJob_CANCELLED && finished.Error != errJobCancelled.Error() {
+			t.Errorf("%v: error %q, want %q", test.name, finished.Error, errJobCancelled)
+		}
+		if finished.FinishTime.IsZero() {
+			t.Errorf("%v: no finish time", test.name)
+		}
+
+		jm.close(time.Now().Add(time.Second))
+		if _, err := limiter.reserveStoredModel("key:pipelines"); err != nil {
+			t.Errorf("%v: quota is not released: %v", test.name, err)
+		}
+		if calls := atomic.LoadInt32(&cleanups); calls != 1 {
+			t.Errorf("%v: cleanup called %v times, want once", test.name, calls)
+		}
+	}
+}
+
+func TestJobsManagerRetention(t *testing.T) {
+	jm := newTestJobsManager(1, 10, 20 * time.Millisecond)
+	defer jm.close(time.Now().Add(time.Second))
+
+	job, err := jm.submit("acme", nil, "", 0, failingSource, func() {})
+	if err != nil {
+		t.Fatalf("submit() error: %v", err)
+	}
+	if _, err := jm.get("other", job.ID); err != errJobNotFound {
+		t.Errorf("other tenant's get() = %v, want %v", err, errJobNotFound)
+	}
+	finished := waitForJobState(t, jm, job.ID, pb.TrainingJob_FAILED)
+	if finished.Error != "broken training data" {
+		t.Errorf("error %q, want the source's error", finished.Error)
+	}
+	waitForJob(t, jm, "acme", job.ID, func(job *TrainingJob, err error) bool {
+		return err == errJobNotFound
+	})
+}
+
+func TestJobsManagerQueueLimit(t *testing.T) {
+	jm := newTestJobsManager(0, 1, time.Hour)
+	if _, err := jm.submit("acme", nil, "", 0, failingSource, func() {}); err != nil {
+		t.Fatalf("submit() error: %v", err)
+	}
+	_, err := jm.submit("acme", nil, "", 0, failingSource, func() {})
+	var quotaErr *quotaError
+	if !errors.As(err, &quotaErr) {
+		t.Errorf("submit() to the full queue = %v, want a quota error", err)
+	}
+}
+
+func TestJobsManagerClose(t *testing.T) {
+	jm := newTestJobsManager(0, 10, time.Hour)
+	var cleanups int32
+	job, err := jm.submit("acme", nil, "", 0, failingSource, func() {
+		atomic.AddInt32(&cleanups, 1)
+	})
+	if err != nil {
+		t.Fatalf("submit() error: %v", err)
+	}
+
+	jm.close(time.Now().Add(time.Second))
+	if failed, _ := jm.get("acme", job.ID); failed.State != pb.TrainingJob_FAILED || failed.Error != errJobsStopped.Error() {
+		t.Errorf("queued job is %v with error %q after close, want FAILED with %q", failed.State, failed.Error, errJobsStopped)
+	}
+	if calls := atomic.LoadInt32(&cleanups); calls != 1 {
+		t.Errorf("cleanup of the queued job called %v times, want once", calls)
+	}
+	if _, err := jm.submit("acme", nil, "", 0, failingSource, func() {}); err != errJobsStopped {
+		t.Errorf("submit() after close = %v, want %v", err, errJobsStopped)
+	}
+}
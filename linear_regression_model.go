@@ -12,6 +12,9 @@ type SimpleRegressionModel struct {
 
 	Coefficient float64
 	Intercept float64
+
+	// DataFingerprint stores the fingerprint of the stored model's training data, see dataFingerprint.
+	DataFingerprint string `json:"DataFingerprint,omitempty"`
 }
 
 // TrainingResults stores the results of simple linear regression model training.
@@ -30,6 +33,16 @@ type TrainingResults struct {
 
 	// CreationTime stores the creation time of the stored model.
 	CreationTime	time.Time	`json:"CreationTime,omitempty"`
+
+	// DroppedInstances and ClampedInstances store the numbers of invalid instances dropped or clamped by the validation.
+	DroppedInstances	int	`json:"DroppedInstances,omitempty"`
+	ClampedInstances	int	`json:"ClampedInstances,omitempty"`
+
+	// InvalidInstances stores the zero-based indices of the first invalid instances.
+	InvalidInstances	[]int	`json:"InvalidInstances,omitempty"`
+
+	// DataFingerprint stores the hex fingerprint of the training instances, see dataFingerprint.
+	DataFingerprint	string	`json:"DataFingerprint,omitempty"`
 }
 
 // ModelValue stores the information about model calculation over the given argument.
@@ -39,4 +39,7 @@ func main() {
 	if os.Args[1] == clientModeArg(statsMode, grpcMode) {
 		runGRPCStats()
 	}
+	if os.Args[1] == clientModeArg(describeMode, grpcMode) {
+		runGRPCDescription()
+	}
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "linear_regression"
+
+// serviceMetrics exports the service execution statistics in Prometheus format.
+type serviceMetrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestsLatency *prometheus.HistogramVec
+	instances       *prometheus.CounterVec
+
+	cacheHits      prometheus.Counter
+	cacheMisses    prometheus.Counter
+	cacheEvictions prometheus.Counter
+
+	storageLatency *prometheus.HistogramVec
+	storageErrors  *prometheus.CounterVec
+}
+
+func newServiceMetrics() *serviceMetrics {
+	sm := serviceMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "requests_total",
+			Help:      "Number of received requests by protocol, method and status.",
+		}, []string{"protocol", "method", "status"}),
+		requestsLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "request_duration_seconds",
+			Help:      "Request processing latency by protocol and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"protocol", "method"}),
+		instances: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "training_instances_total",
+			Help:      "Number of instances used while learning models by protocol.",
+		}, []string{"protocol"}),
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cache_hits_total",
+			Help:      "Number of models taken from the local cache.",
+		}),
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cache_misses_total",
+			Help:      "Number of models not found in the local cache.",
+		}),
+		cacheEvictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cache_evictions_total",
+			Help:      "Number of models evicted from the local cache.",
+		}),
+		storageLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "spanner_call_duration_seconds",
+			Help:      "Spanner call latency by operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+		storageErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "spanner_errors_total",
+			Help:      "Number of failed Spanner calls by operation.",
+		}, []string{"operation"}),
+	}
+
+	sm.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		sm.requests,
+		sm.requestsLatency,
+		sm.instances,
+		sm.cacheHits,
+		sm.cacheMisses,
+		sm.cacheEvictions,
+		sm.storageLatency,
+		sm.storageErrors,
+	)
+	return &sm
+}
+
+func (sm *serviceMetrics) observeRequest(request *requestStats, latency time.Duration) {
+	protocol := protocolPrefix(request.key.protocol)
+	method := operationName(request.key.operation)
+
+	status := "failed"
+	if request.Succeeded {
+		status = "succeeded"
+	}
+	sm.requests.WithLabelValues(protocol, method, status).Inc()
+	sm.requestsLatency.WithLabelValues(protocol, method).Observe(latency.Seconds())
+	if request.Instances > 0 {
+		sm.instances.WithLabelValues(protocol).Add(float64(request.Instances))
+	}
+}
+
+func (sm *serviceMetrics) observeCacheLookup(hit bool) {
+	if hit {
+		sm.cacheHits.Inc()
+	} else {
+		sm.cacheMisses.Inc()
+	}
+}
+
+func (sm *serviceMetrics) observeStorageCall(operation string, latency time.Duration, err error) {
+	sm.storageLatency.WithLabelValues(operation).Observe(latency.Seconds())
+	if err != nil {
+		sm.storageErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// handler returns the HTTP handler serving the metrics in Prometheus text format.
+func (sm *serviceMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(sm.registry, promhttp.HandlerOpts{})
+}
+
+// runMetricsHandler serves the metrics for the handlers which do not run an http server on their own.
+func runMetricsHandler(address string, handler http.Handler, tlsConfig *tls.Config) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+
+	server := &http.Server{Addr: address, Handler: mux, TLSConfig: tlsConfig}
+	var err error
+	if tlsConfig != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil {
+		log.Fatal("metrics ListenAndServe:", err)
+	}
+}
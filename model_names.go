@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"math"
+)
+
+// contentNameBytes is the number of the hash bytes a content-addressed model name encodes; 15 bytes make 20 characters
+// without padding, so that the content-addressed names never look like the random ones.
+const contentNameBytes = 15
+
+// dataFingerprint hashes the training instances the model is trained on, in their order: SHA-256 of the feature, the target
+// and the weight of every instance accepted by the validation, each as a little-endian IEEE 754 double.
+// The fingerprint does not depend on the training data format, so the same instances sent as JSON or CSV match.
+type dataFingerprint struct {
+	hash   hash.Hash
+	buffer [24]byte
+}
+
+func newDataFingerprint() *dataFingerprint {
+	return &dataFingerprint{hash: sha256.New()}
+}
+
+func (df *dataFingerprint) add(feature float64, target float64, weight float64) {
+	binary.LittleEndian.PutUint64(df.buffer[0:], math.Float64bits(feature))
+	binary.LittleEndian.PutUint64(df.buffer[8:], math.Float64bits(target))
+	binary.LittleEndian.PutUint64(df.buffer[16:], math.Float64bits(weight))
+	df.hash.Write(df.buffer[:])
+}
+
+// String returns the fingerprint in hex.
+func (df *dataFingerprint) String() string {
+	return hex.EncodeToString(df.hash.Sum(nil))
+}
+
+// contentModelName derives the model name from its parameters and the fingerprint of its training data: the first
+// contentNameBytes of SHA-256 of the coefficient and the intercept as little-endian IEEE 754 doubles followed by the
+// fingerprint's bytes, URL-safe base64 encoded. Identical training runs produce the same name.
+func contentModelName(model *SimpleRegressionModel, fingerprint string) (string, error) {
+	fingerprintBytes, err := hex.DecodeString(fingerprint)
+	if err != nil || len(fingerprintBytes) != sha256.Size {
+		return "", fmt.Errorf("invalid training data fingerprint %q", fingerprint)
+	}
+
+	var params [16]byte
+	binary.LittleEndian.PutUint64(params[0:], math.Float64bits(model.Coefficient))
+	binary.LittleEndian.PutUint64(params[8:], math.Float64bits(model.Intercept))
+
+	contentHash := sha256.New()
+	contentHash.Write(params[:])
+	contentHash.Write(fingerprintBytes)
+	return base64.URLEncoding.EncodeToString(contentHash.Sum(nil)[:contentNameBytes]), nil
+}
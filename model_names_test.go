@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func fingerprintOf(instances [][3]float64) string {
+	fingerprint := newDataFingerprint()
+	for _, instance := range instances {
+		fingerprint.add(instance[0], instance[1], instance[2])
+	}
+	return fingerprint.String()
+}
+
+func TestContentModelName(t *testing.T) {
+	model := &SimpleRegressionModel{Coefficient: 2, Intercept: 1}
+	fingerprint := fingerprintOf([][3]float64{{1, 3, 1}, {2, 5, 1}})
+
+	name, err := contentModelName(model, fingerprint)
+	if err != nil {
+		t.Fatalf("contentModelName() error: %v", err)
+	}
+	if len(name) != 20 || strings.HasSuffix(name, "=") {
+		t.Errorf("name %q must have 20 characters without padding", name)
+	}
+	if again, _ := contentModelName(&SimpleRegressionModel{Coefficient: 2, Intercept: 1}, fingerprint); again != name {
+		t.Errorf("identical models are named %q and %q", name, again)
+	}
+	if other, _ := contentModelName(&SimpleRegressionModel{Coefficient: 2, Intercept: 1.5}, fingerprint); other == name {
+		t.Errorf("different models are both named %q", name)
+	}
+	if other, _ := contentModelName(model, fingerprintOf([][3]float64{{2, 5, 1}, {1, 3, 1}})); other == name {
+		t.Errorf("models trained on differently ordered instances are both named %q", name)
+	}
+}
+
+func TestContentModelNameRejectsInvalidFingerprint(t *testing.T) {
+	for _, fingerprint := range []string{"", "not hex", "abcd"} {
+		if _, err := contentModelName(&SimpleRegressionModel{}, fingerprint); err == nil {
+			t.Errorf("fingerprint %q is accepted", fingerprint)
+		}
+	}
+}
@@ -4,39 +4,45 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
 	"cloud.google.com/go/spanner"
 	"github.com/golang/groupcache/lru"
+	"google.golang.org/grpc/codes"
 )
 
+var errModelNotFound = errors.New("model not found")
+
 type modelsStorage struct {
 	spannerClient *spanner.Client
 	modelsCache *lru.Cache
+	stats *statsCollector
+
+	// idempotencyTTL is the time the idempotency keys are kept for.
+	idempotencyTTL time.Duration
+
+	// contentAddressedNames derives the model names from the models and their training data instead of choosing them randomly.
+	contentAddressedNames bool
 
 	mutex sync.Mutex
 }
 
-func newModelsStorage(ctx context.Context) (*modelsStorage, error) {
-	project := ctx.Value("project")
-	instance := ctx.Value("instance")
-	database := ctx.Value("database")
-
-	spannerBaseAddress := fmt.Sprintf("projects/%v/instances/%v/databases/%v", project, instance, database)
+func newModelsStorage(ctx context.Context, config storageConfig, stats *statsCollector) (*modelsStorage, error) {
+	spannerBaseAddress := fmt.Sprintf("projects/%v/instances/%v/databases/%v", config.Project, config.Instance, config.Database)
 	spannerClient, err := spanner.NewClient(ctx, spannerBaseAddress)
 	if err != nil {
 		return nil, fmt.Errorf("spanner.NewClient() error: %v", err)
 	}
 
-	maxCacheItems := 100
-	if cacheSizeParam := ctx.Value("max-cache"); cacheSizeParam != nil {
-		maxCacheItems = cacheSizeParam.(int)
+	modelsCache := lru.New(config.MaxCache)
+	modelsCache.OnEvicted = func(lru.Key, interface{}) {
+		stats.reportCacheEviction()
 	}
-
-	modelsCache := lru.New(maxCacheItems)
-	return &modelsStorage{spannerClient: spannerClient, modelsCache: modelsCache}, nil
+	return &modelsStorage{spannerClient: spannerClient, modelsCache: modelsCache, stats: stats,
+		idempotencyTTL: config.IdempotencyTTL, contentAddressedNames: config.ContentAddressedNames}, nil
 }
 
 func randomModelName() (string, error) {
@@ -47,54 +53,118 @@ func randomModelName() (string, error) {
 	return base64.URLEncoding.EncodeToString(b), nil
 }
 
-func (ms *modelsStorage) saveSLRModel(ctx context.Context, model *SimpleRegressionModel) (string, time.Time, error) {
-	name, err := randomModelName()
+// newModelName chooses the name of the model being stored, see contentModelName and randomModelName.
+func (ms *modelsStorage) newModelName(model *SimpleRegressionModel, fingerprint string) (string, error) {
+	if ms.contentAddressedNames {
+		return contentModelName(model, fingerprint)
+	}
+	return randomModelName()
+}
+
+// existingModelCreationTime returns the creation time of the stored model with a content-addressed name, or zero time
+// if there is none; an identical model is not stored again. Random names are never checked.
+func (ms *modelsStorage) existingModelCreationTime(ctx context.Context, txn *spanner.ReadWriteTransaction, tenant string, name string) (time.Time, error) {
+	if !ms.contentAddressedNames {
+		return time.Time{}, nil
+	}
+	row, err := txn.ReadRow(ctx, "slr_models", spanner.Key{tenant, name}, []string{"creation_time"})
+	if spanner.ErrCode(err) == codes.NotFound {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	var creationTime time.Time
+	if err := row.Columns(&creationTime); err != nil {
+		return time.Time{}, fmt.Errorf("error loading model creation time from Spanner row: %v", err)
+	}
+	return creationTime, nil
+}
+
+// modelKey identifies a model both in Spanner and in the local cache.
+type modelKey struct {
+	tenant string
+	name   string
+}
+
+// saveSLRModel stores the model trained on the data with the given fingerprint. A model with a content-addressed name
+// is written in a transaction checking if it is already stored; if it is, the model is not stored again, created is not set
+// and the creation time of the stored one is returned.
+func (ms *modelsStorage) saveSLRModel(ctx context.Context, tenant string, model *SimpleRegressionModel, fingerprint string) (name string, creationTime time.Time, created bool, err error) {
+	name, err = ms.newModelName(model, fingerprint)
 	if err != nil {
-		return "", time.Time{}, err
+		return "", time.Time{}, false, err
 	}
 
-	commitTS, err := ms.spannerClient.Apply(ctx, []*spanner.Mutation{
+	mutations := []*spanner.Mutation{
 		spanner.Insert("slr_models",
-			[]string{"name", "params", "creation_time"},
-			[]interface{}{name, model.ToFloatArray(), spanner.CommitTimestamp},
+			[]string{"tenant", "name", "params", "data_fingerprint", "creation_time"},
+			[]interface{}{tenant, name, model.ToFloatArray(), fingerprint, spanner.CommitTimestamp},
 		),
-	})
+	}
+	var commitTS, existingCreationTime time.Time
+	started := time.Now()
+	if ms.contentAddressedNames {
+		commitTS, err = ms.spannerClient.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+			var err error
+			if existingCreationTime, err = ms.existingModelCreationTime(ctx, txn, tenant, name); err != nil || !existingCreationTime.IsZero() {
+				return err
+			}
+			return txn.BufferWrite(mutations)
+		})
+	} else {
+		commitTS, err = ms.spannerClient.Apply(ctx, mutations)
+	}
+	ms.stats.reportStorageWrite(started, err)
 	if err != nil {
-		return "", time.Time{}, fmt.Errorf("cannot save model to Spanner: %v", err)
+		return "", time.Time{}, false, fmt.Errorf("cannot save model to Spanner: %v", err)
 	}
 
-	return name, commitTS, nil
+	if !existingCreationTime.IsZero() {
+		return name, existingCreationTime.UTC(), false, nil
+	}
+	return name, commitTS.UTC(), true, nil
 }
 
-func (ms *modelsStorage) safeGetModelFromCache(name string) (*SimpleRegressionModel, bool) {
+func (ms *modelsStorage) safeGetModelFromCache(key modelKey) (*SimpleRegressionModel, bool) {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
-	if modelFromCache, ok := ms.modelsCache.Get(name); ok {
+	if modelFromCache, ok := ms.modelsCache.Get(key); ok {
 		return modelFromCache.(*SimpleRegressionModel), true
 	}
 	return nil, false
 }
 
-func (ms *modelsStorage) safeAddModelToCache(name string, model *SimpleRegressionModel) {
+func (ms *modelsStorage) safeAddModelToCache(key modelKey, model *SimpleRegressionModel) {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
-	ms.modelsCache.Add(name, model)
+	ms.modelsCache.Add(key, model)
 }
 
-func (ms *modelsStorage) getSLRModel(ctx context.Context, name string) (*SimpleRegressionModel, bool, error) {
-	if modelFromCache, ok := ms.safeGetModelFromCache(name); ok {
+func (ms *modelsStorage) getSLRModel(ctx context.Context, tenant string, name string) (*SimpleRegressionModel, bool, error) {
+	key := modelKey{tenant: tenant, name: name}
+	modelFromCache, ok := ms.safeGetModelFromCache(key)
+	ms.stats.reportCacheLookup(ok)
+	if ok {
 		return modelFromCache, true, nil
 	}
 
+	started := time.Now()
 	row, err := ms.spannerClient.Single().ReadRow(ctx, "slr_models",
-		spanner.Key{name}, []string{"params"})
+		spanner.Key{tenant, name}, []string{"params", "data_fingerprint"})
+	if spanner.ErrCode(err) == codes.NotFound {
+		ms.stats.reportStorageRead(started, nil)
+		return nil, false, errModelNotFound
+	}
+	ms.stats.reportStorageRead(started, err)
 	if err != nil {
 		return nil, false, fmt.Errorf("error loading model from Spanner: %v", err)
 	}
 	var params []float64
-	if err = row.Columns(&params); err != nil {
+	var fingerprint spanner.NullString
+	if err = row.Columns(&params, &fingerprint); err != nil {
 		return nil, false, fmt.Errorf("error loading parameters from Spanner row: %v", err)
 	}
 
@@ -102,7 +172,54 @@ func (ms *modelsStorage) getSLRModel(ctx context.Context, name string) (*SimpleR
 	if err != nil {
 		return nil, false, err
 	}
-	ms.safeAddModelToCache(name, model)
+	// The models stored before the fingerprints were introduced have none.
+	model.DataFingerprint = fingerprint.StringVal
+	ms.safeAddModelToCache(key, model)
 
 	return model, false, nil
 }
+
+func (ms *modelsStorage) safeRemoveModelFromCache(key modelKey) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.modelsCache.Remove(key)
+}
+
+// deleteSLRModel removes the model from Spanner and from the local cache; the caches of other handlers
+// keep serving the model until it is evicted.
+func (ms *modelsStorage) deleteSLRModel(ctx context.Context, tenant string, name string) error {
+	started := time.Now()
+	_, err := ms.spannerClient.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		if _, err := txn.ReadRow(ctx, "slr_models", spanner.Key{tenant, name}, []string{"name"}); err != nil {
+			return err
+		}
+		return txn.BufferWrite([]*spanner.Mutation{spanner.Delete("slr_models", spanner.Key{tenant, name})})
+	})
+	if spanner.ErrCode(err) == codes.NotFound {
+		ms.stats.reportStorageWrite(started, nil)
+		return errModelNotFound
+	}
+	ms.stats.reportStorageWrite(started, err)
+	if err != nil {
+		return fmt.Errorf("cannot delete model from Spanner: %v", err)
+	}
+
+	ms.safeRemoveModelFromCache(modelKey{tenant: tenant, name: name})
+	return nil
+}
+
+// checkConnectivity makes sure the Spanner database is reachable by running a trivial query.
+func (ms *modelsStorage) checkConnectivity(ctx context.Context) error {
+	iter := ms.spannerClient.Single().Query(ctx, spanner.NewStatement("SELECT 1"))
+	defer iter.Stop()
+
+	if _, err := iter.Next(); err != nil {
+		return fmt.Errorf("spanner is not reachable: %v", err)
+	}
+	return nil
+}
+
+func (ms *modelsStorage) close() {
+	ms.spannerClient.Close()
+}
@@ -0,0 +1,696 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// openAPIDocument describes the http API; it must be updated together with the handlers, the JSON types
+// and the google.api.http options of regression.proto.
+const openAPIDocument = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Linear regression service",
+    "description": "Trains, stores and calculates simple linear regression models f(x) = a * x + b.",
+    "version": "1.0.0"
+  },
+  "security": [
+    {"bearerAuth": []},
+    {"apiKeyAuth": []}
+  ],
+  "paths": {
+    "/v1/models": {
+      "post": {
+        "operationId": "createModel",
+        "summary": "Train and store a model on uploaded training data",
+        "description": "Requires the train scope. Unlike the rest of the versioned API it is not transcoded to gRPC, so that the training data may be delimited, Parquet or Arrow.",
+        "parameters": [
+          {"$ref": "#/components/parameters/tenant"},
+          {"$ref": "#/components/parameters/feature"},
+          {"$ref": "#/components/parameters/target"},
+          {"$ref": "#/components/parameters/weight"},
+          {"$ref": "#/components/parameters/header"},
+          {"$ref": "#/components/parameters/idempotency_key"}
+        ],
+        "requestBody": {"$ref": "#/components/requestBodies/TrainingData"},
+        "responses": {
+          "201": {
+            "description": "The model is trained and stored, or the results stored under the idempotency key are returned.",
+            "headers": {
+              "Location": {"description": "Path of the created model.", "schema": {"type": "string"}},
+              "Idempotent-Replayed": {"$ref": "#/components/headers/Idempotent-Replayed"}
+            },
+            "content": {
+              "application/json": {"schema": {"$ref": "#/components/schemas/linear_regression.TrainingResults"}},
+              "application/x-protobuf": {"schema": {"type": "string", "format": "binary"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "413": {"$ref": "#/components/responses/TooLarge"},
+          "415": {"$ref": "#/components/responses/UnsupportedMediaType"},
+          "422": {"$ref": "#/components/responses/IdempotencyKeyReused"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/v1/models:train": {
+      "post": {
+        "operationId": "Regression_Train",
+        "summary": "Train a model, storing it if requested",
+        "description": "Transcoded to the Train gRPC method, requires the train scope.",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {"schema": {"$ref": "#/components/schemas/linear_regression.TrainingRequest"}}
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "The training results.",
+            "content": {
+              "application/json": {"schema": {"$ref": "#/components/schemas/linear_regression.TrainingResults"}},
+              "application/x-protobuf": {"schema": {"type": "string", "format": "binary"}}
+            }
+          },
+          "default": {"$ref": "#/components/responses/Status"}
+        }
+      }
+    },
+    "/v1/models/{model_name}": {
+      "parameters": [
+        {"$ref": "#/components/parameters/model_name"}
+      ],
+      "get": {
+        "operationId": "Regression_GetModel",
+        "summary": "Get the model's parameters",
+        "description": "Transcoded to the GetModel gRPC method, requires the calc scope.",
+        "parameters": [
+          {"$ref": "#/components/parameters/tenant"}
+        ],
+        "responses": {
+          "200": {
+            "description": "The model.",
+            "content": {
+              "application/json": {"schema": {"$ref": "#/components/schemas/linear_regression.SimpleRegressionModel"}},
+              "application/x-protobuf": {"schema": {"type": "string", "format": "binary"}}
+            }
+          },
+          "default": {"$ref": "#/components/responses/Status"}
+        }
+      },
+      "delete": {
+        "operationId": "Regression_DeleteModel",
+        "summary": "Remove the model",
+        "description": "Transcoded to the DeleteModel gRPC method, requires the train scope.",
+        "parameters": [
+          {"$ref": "#/components/parameters/tenant"}
+        ],
+        "responses": {
+          "200": {"description": "The model is removed.", "content": {"application/json": {"schema": {"type": "object"}}}},
+          "default": {"$ref": "#/components/responses/Status"}
+        }
+      }
+    },
+    "/v1/models/{model_name}:predict": {
+      "parameters": [
+        {"$ref": "#/components/parameters/model_name"}
+      ],
+      "post": {
+        "operationId": "Regression_Calculate",
+        "summary": "Calculate the model's value",
+        "description": "Transcoded to the Calculate gRPC method, requires the calc scope.",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {"schema": {"$ref": "#/components/schemas/linear_regression.CalculateRequest"}}
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "The model's value.",
+            "content": {
+              "application/json": {"schema": {"$ref": "#/components/schemas/linear_regression.ModelValue"}},
+              "application/x-protobuf": {"schema": {"type": "string", "format": "binary"}}
+            }
+          },
+          "default": {"$ref": "#/components/responses/Status"}
+        }
+      }
+    },
+    "/v1/jobs": {
+      "post": {
+        "operationId": "submitJob",
+        "summary": "Queue a job training and storing a model on uploaded training data",
+        "description": "Requires the train scope. The training data is saved before the response and trained on by a worker afterwards; poll the job by the Location header. Not transcoded to gRPC, so that the training data may be delimited, Parquet or Arrow.",
+        "parameters": [
+          {"$ref": "#/components/parameters/tenant"},
+          {"$ref": "#/components/parameters/feature"},
+          {"$ref": "#/components/parameters/target"},
+          {"$ref": "#/components/parameters/weight"},
+          {"$ref": "#/components/parameters/header"},
+          {"$ref": "#/components/parameters/idempotency_key"}
+        ],
+        "requestBody": {"$ref": "#/components/requestBodies/TrainingData"},
+        "responses": {
+          "202": {
+            "description": "The job is queued.",
+            "headers": {
+              "Location": {"description": "Path of the job.", "schema": {"type": "string"}}
+            },
+            "content": {
+              "application/json": {"schema": {"$ref": "#/components/schemas/linear_regression.TrainingJob"}},
+              "application/x-protobuf": {"schema": {"type": "string", "format": "binary"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "413": {"$ref": "#/components/responses/TooLarge"},
+          "415": {"$ref": "#/components/responses/UnsupportedMediaType"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/InternalError"},
+          "503": {"description": "The service is shutting down.", "content": {"text/plain": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+        }
+      }
+    },
+    "/v1/jobs:train": {
+      "post": {
+        "operationId": "Regression_SubmitTrainingJob",
+        "summary": "Queue a job training and storing a model",
+        "description": "Transcoded to the SubmitTrainingJob gRPC method, requires the train scope. The model is always stored, storeModel is ignored.",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {"schema": {"$ref": "#/components/schemas/linear_regression.TrainingRequest"}}
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "The queued job.",
+            "content": {
+              "application/json": {"schema": {"$ref": "#/components/schemas/linear_regression.TrainingJob"}},
+              "application/x-protobuf": {"schema": {"type": "string", "format": "binary"}}
+            }
+          },
+          "default": {"$ref": "#/components/responses/Status"}
+        }
+      }
+    },
+    "/v1/jobs/{job_id}": {
+      "parameters": [
+        {"$ref": "#/components/parameters/job_id"}
+      ],
+      "get": {
+        "operationId": "Regression_GetJob",
+        "summary": "Get the job's state, progress and results",
+        "description": "Transcoded to the GetJob gRPC method, requires the train scope. The finished jobs are kept for the retention time.",
+        "parameters": [
+          {"$ref": "#/components/parameters/tenant"}
+        ],
+        "responses": {
+          "200": {
+            "description": "The job.",
+            "content": {
+              "application/json": {"schema": {"$ref": "#/components/schemas/linear_regression.TrainingJob"}},
+              "application/x-protobuf": {"schema": {"type": "string", "format": "binary"}}
+            }
+          },
+          "default": {"$ref": "#/components/responses/Status"}
+        }
+      }
+    },
+    "/v1/jobs/{job_id}:cancel": {
+      "parameters": [
+        {"$ref": "#/components/parameters/job_id"}
+      ],
+      "post": {
+        "operationId": "Regression_CancelJob",
+        "summary": "Cancel the queued or running job",
+        "description": "Transcoded to the CancelJob gRPC method, requires the train scope. A running job stays RUNNING until its worker notices the cancellation.",
+        "requestBody": {
+          "content": {
+            "application/json": {"schema": {"$ref": "#/components/schemas/linear_regression.CancelJobRequest"}}
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "The job.",
+            "content": {
+              "application/json": {"schema": {"$ref": "#/components/schemas/linear_regression.TrainingJob"}},
+              "application/x-protobuf": {"schema": {"type": "string", "format": "binary"}}
+            }
+          },
+          "default": {"$ref": "#/components/responses/Status"}
+        }
+      }
+    },
+    "/v1/stats": {
+      "get": {
+        "operationId": "Regression_Stats",
+        "summary": "Get the execution stats",
+        "description": "Transcoded to the Stats gRPC method, requires the stats scope.",
+        "responses": {
+          "200": {
+            "description": "The execution stats.",
+            "content": {
+              "application/json": {"schema": {"$ref": "#/components/schemas/linear_regression.ServerStats"}},
+              "application/x-protobuf": {"schema": {"type": "string", "format": "binary"}}
+            }
+          },
+          "default": {"$ref": "#/components/responses/Status"}
+        }
+      }
+    },
+    "/train": {
+      "post": {
+        "operationId": "train",
+        "summary": "Train a model, storing it if requested",
+        "description": "Legacy endpoint, requires the train scope. Storage errors are reported in the Error field of the results.",
+        "deprecated": true,
+        "parameters": [
+          {"$ref": "#/components/parameters/tenant"},
+          {"name": "store", "in": "query", "description": "Store the model: 1 or true.", "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/feature"},
+          {"$ref": "#/components/parameters/target"},
+          {"$ref": "#/components/parameters/weight"},
+          {"$ref": "#/components/parameters/header"},
+          {"$ref": "#/components/parameters/idempotency_key"}
+        ],
+        "requestBody": {"$ref": "#/components/requestBodies/TrainingData"},
+        "responses": {
+          "200": {
+            "description": "The training results.",
+            "headers": {
+              "Idempotent-Replayed": {"$ref": "#/components/headers/Idempotent-Replayed"}
+            },
+            "content": {
+              "application/json": {"schema": {"$ref": "#/components/schemas/TrainingResults"}},
+              "text/csv": {"schema": {"type": "string"}},
+              "application/x-protobuf": {"schema": {"type": "string", "format": "binary"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "406": {"$ref": "#/components/responses/NotAcceptable"},
+          "413": {"$ref": "#/components/responses/TooLarge"},
+          "415": {"$ref": "#/components/responses/UnsupportedMediaType"},
+          "422": {"$ref": "#/components/responses/IdempotencyKeyReused"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"}
+        }
+      }
+    },
+    "/calc": {
+      "get": {
+        "operationId": "calc",
+        "summary": "Calculate the model's value",
+        "description": "Legacy endpoint, requires the calc scope. Missing or malformed keys are reported with 500.",
+        "deprecated": true,
+        "parameters": [
+          {"name": "model", "in": "query", "required": true, "schema": {"type": "string"}},
+          {"name": "arg", "in": "query", "required": true, "schema": {"type": "number", "format": "double"}},
+          {"$ref": "#/components/parameters/tenant"}
+        ],
+        "responses": {
+          "200": {
+            "description": "The model's value.",
+            "content": {
+              "application/json": {"schema": {"$ref": "#/components/schemas/ModelValue"}},
+              "text/csv": {"schema": {"type": "string"}},
+              "application/x-protobuf": {"schema": {"type": "string", "format": "binary"}}
+            }
+          },
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "406": {"$ref": "#/components/responses/NotAcceptable"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/stats": {
+      "get": {
+        "operationId": "stats",
+        "summary": "Get the execution stats",
+        "description": "Legacy endpoint, requires the stats scope.",
+        "deprecated": true,
+        "responses": {
+          "200": {
+            "description": "The execution stats.",
+            "content": {
+              "application/json": {"schema": {"$ref": "#/components/schemas/ExecutionStats"}},
+              "text/csv": {"schema": {"type": "string"}},
+              "application/x-protobuf": {"schema": {"type": "string", "format": "binary"}}
+            }
+          },
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "406": {"$ref": "#/components/responses/NotAcceptable"}
+        }
+      }
+    },
+    "/metrics": {
+      "get": {
+        "operationId": "metrics",
+        "summary": "Prometheus metrics",
+        "description": "Requires the stats scope.",
+        "responses": {
+          "200": {"description": "Metrics in the Prometheus text format.", "content": {"text/plain": {"schema": {"type": "string"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"}
+        }
+      }
+    },
+    "/healthz": {
+      "get": {
+        "operationId": "liveness",
+        "summary": "Liveness probe",
+        "security": [],
+        "responses": {
+          "200": {"description": "The process is alive.", "content": {"text/plain": {"schema": {"type": "string"}}}}
+        }
+      }
+    },
+    "/readyz": {
+      "get": {
+        "operationId": "readiness",
+        "summary": "Readiness probe",
+        "description": "Checks that Spanner is reachable.",
+        "security": [],
+        "responses": {
+          "200": {"description": "The handler is ready.", "content": {"text/plain": {"schema": {"type": "string"}}}},
+          "503": {"description": "Spanner is not reachable.", "content": {"text/plain": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+        }
+      }
+    },
+    "/openapi.json": {
+      "get": {
+        "operationId": "openAPI",
+        "summary": "This document",
+        "security": [],
+        "responses": {
+          "200": {"description": "The OpenAPI document.", "content": {"application/json": {"schema": {"type": "object"}}}}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearerAuth": {"type": "http", "scheme": "bearer"},
+      "apiKeyAuth": {"type": "apiKey", "in": "header", "name": "X-API-Key"}
+    },
+    "parameters": {
+      "model_name": {"name": "model_name", "in": "path", "required": true, "description": "Model name.", "schema": {"type": "string"}},
+      "job_id": {"name": "job_id", "in": "path", "required": true, "description": "Training job id.", "schema": {"type": "string"}},
+      "tenant": {"name": "tenant", "in": "query", "description": "Tenant owning the models; taken from the API key if empty.", "schema": {"type": "string"}},
+      "feature": {"name": "feature", "in": "query", "description": "Feature column of the delimited and columnar data: zero-based index or name.", "schema": {"type": "string"}},
+      "target": {"name": "target", "in": "query", "description": "Target column of the delimited and columnar data: zero-based index or name.", "schema": {"type": "string"}},
+      "weight": {"name": "weight", "in": "query", "description": "Weight column of the delimited and columnar data: zero-based index or name.", "schema": {"type": "string"}},
+      "header": {"name": "header", "in": "query", "description": "Skip the first line of the delimited data: 1 or true.", "schema": {"type": "string"}},
+      "idempotency_key": {"name": "Idempotency-Key", "in": "header", "description": "Retries of the request storing the model with the same key return the results of the first one instead of storing another model; a retry with different training data is rejected with 422. Ignored if the model is not stored.", "schema": {"type": "string", "maxLength": 255}}
+    },
+    "headers": {
+      "Idempotent-Replayed": {"description": "true if the results stored under the idempotency key by a previous request are returned.", "schema": {"type": "string", "enum": ["true"]}}
+    },
+    "requestBodies": {
+      "TrainingData": {
+        "required": true,
+        "description": "Training instances: feature, target and optional weight.",
+        "content": {
+          "application/json": {"schema": {"$ref": "#/components/schemas/TrainingInstances"}},
+          "text/tab-separated-values": {"schema": {"type": "string"}},
+          "text/csv": {"schema": {"type": "string"}},
+          "application/vnd.apache.parquet": {"schema": {"type": "string", "format": "binary"}},
+          "application/vnd.apache.arrow.file": {"schema": {"type": "string", "format": "binary"}},
+          "application/vnd.apache.arrow.stream": {"schema": {"type": "string", "format": "binary"}}
+        }
+      }
+    },
+    "responses": {
+      "BadRequest": {"description": "Malformed or invalid request.", "content": {"text/plain": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Unauthorized": {"description": "Missing or invalid API key.", "content": {"text/plain": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Forbidden": {"description": "The API key lacks the scope or the tenant.", "content": {"text/plain": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "NotFound": {"description": "The model is not found.", "content": {"text/plain": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "NotAcceptable": {"description": "None of the accepted response formats is supported.", "content": {"text/plain": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "IdempotencyKeyReused": {"description": "The idempotency key is already used for different training data.", "content": {"text/plain": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "TooLarge": {"description": "The request body exceeds the size limit.", "content": {"text/plain": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "UnsupportedMediaType": {"description": "Unsupported training data content type.", "content": {"text/plain": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "TooManyRequests": {
+        "description": "Rate limit or quota exceeded.",
+        "headers": {"Retry-After": {"description": "Seconds to wait before retrying rate limited requests.", "schema": {"type": "integer"}}},
+        "content": {"text/plain": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "InternalError": {"description": "Storage or internal failure.", "content": {"text/plain": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Status": {"description": "gRPC status of the failed transcoded call; the http status code corresponds to the gRPC code.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/google.rpc.Status"}}}}
+    },
+    "schemas": {
+      "Error": {
+        "type": "string",
+        "description": "The hand-written endpoints report errors as plain text messages."
+      },
+      "google.rpc.Status": {
+        "type": "object",
+        "properties": {
+          "code": {"type": "integer", "description": "gRPC status code."},
+          "message": {"type": "string"},
+          "details": {"type": "array", "items": {"type": "object"}}
+        }
+      },
+      "TrainingInstances": {
+        "type": "array",
+        "items": {
+          "type": "array",
+          "description": "Feature, target and optional weight.",
+          "items": {"type": "number", "format": "double"},
+          "minItems": 2,
+          "maxItems": 3
+        }
+      },
+      "SimpleRegressionModel": {
+        "type": "object",
+        "description": "f(x) = Coefficient * x + Intercept.",
+        "properties": {
+          "Name": {"type": "string"},
+          "Coefficient": {"type": "number", "format": "double"},
+          "Intercept": {"type": "number", "format": "double"},
+          "DataFingerprint": {"type": "string", "description": "Hex SHA-256 of the stored model's training instances."}
+        }
+      },
+      "TrainingResults": {
+        "type": "object",
+        "description": "Training results of the legacy /train endpoint.",
+        "properties": {
+          "Model": {"$ref": "#/components/schemas/SimpleRegressionModel"},
+          "SumSquaredErrors": {"type": "number", "format": "double"},
+          "Name": {"type": "string", "description": "Name of the stored model."},
+          "Error": {"type": "string", "description": "Storage error message."},
+          "CreationTime": {"type": "string", "format": "date-time", "description": "Commit time of the stored model in UTC."},
+          "DroppedInstances": {"type": "integer"},
+          "ClampedInstances": {"type": "integer"},
+          "InvalidInstances": {"type": "array", "description": "Positions of the first invalid instances: line numbers of TSV and CSV data, zero-based row numbers of Parquet and Arrow data, zero-based indices of JSON arrays.", "items": {"type": "integer"}},
+          "DataFingerprint": {"type": "string", "description": "Hex SHA-256 of the training instances."}
+        }
+      },
+      "ModelValue": {
+        "type": "object",
+        "properties": {
+          "Value": {"type": "number", "format": "double"},
+          "Argument": {"type": "number", "format": "double"},
+          "Model": {"$ref": "#/components/schemas/SimpleRegressionModel"},
+          "FromCache": {"type": "boolean"},
+          "CalculationTime": {"type": "string", "format": "date-time", "description": "UTC."}
+        }
+      },
+      "MethodStats": {
+        "type": "object",
+        "properties": {
+          "Protocol": {"type": "string", "enum": ["http", "grpc"]},
+          "Method": {"type": "string", "enum": ["train", "calc", "stats", "get_model", "delete_model", "submit_job", "get_job", "cancel_job"]},
+          "SucceededRequests": {"type": "integer"},
+          "TotalRequests": {"type": "integer"},
+          "TotalInstances": {"type": "integer"}
+        }
+      },
+      "TenantStats": {
+        "type": "object",
+        "properties": {
+          "Tenant": {"type": "string"},
+          "SucceededRequests": {"type": "integer"},
+          "TotalRequests": {"type": "integer"},
+          "TotalInstances": {"type": "integer"}
+        }
+      },
+      "WindowStats": {
+        "type": "object",
+        "properties": {
+          "Window": {"type": "string", "enum": ["1m", "5m", "1h"]},
+          "TotalRequests": {"type": "integer"},
+          "FailedRequests": {"type": "integer"},
+          "RequestRate": {"type": "number", "format": "double", "description": "Requests per second."},
+          "ErrorRate": {"type": "number", "format": "double", "description": "Share of failed requests."},
+          "LatencyP50": {"type": "number", "format": "double", "description": "Milliseconds."},
+          "LatencyP95": {"type": "number", "format": "double", "description": "Milliseconds."},
+          "LatencyP99": {"type": "number", "format": "double", "description": "Milliseconds."}
+        }
+      },
+      "ExecutionStats": {
+        "type": "object",
+        "properties": {
+          "SucceededRequests": {"type": "integer"},
+          "TotalRequests": {"type": "integer"},
+          "TotalInstances": {"type": "integer"},
+          "CacheHits": {"type": "integer"},
+          "CacheMisses": {"type": "integer"},
+          "StorageReads": {"type": "integer"},
+          "StorageWrites": {"type": "integer"},
+          "Methods": {"type": "array", "items": {"$ref": "#/components/schemas/MethodStats"}},
+          "Tenants": {"type": "array", "items": {"$ref": "#/components/schemas/TenantStats"}},
+          "Windows": {"type": "array", "items": {"$ref": "#/components/schemas/WindowStats"}}
+        }
+      },
+      "linear_regression.Instance": {
+        "type": "object",
+        "properties": {
+          "argument": {"type": "number", "format": "double"},
+          "target": {"type": "number", "format": "double"},
+          "weight": {"type": "number", "format": "double"}
+        }
+      },
+      "linear_regression.TrainingRequest": {
+        "type": "object",
+        "properties": {
+          "instances": {"type": "array", "items": {"$ref": "#/components/schemas/linear_regression.Instance"}},
+          "storeModel": {"type": "boolean"},
+          "tenant": {"type": "string", "description": "Tenant owning the stored model; taken from the API key if empty."},
+          "idempotencyKey": {"type": "string", "maxLength": 255, "description": "Retries of the request storing the model with the same key return the results of the first one instead of storing another model."}
+        }
+      },
+      "linear_regression.CalculateRequest": {
+        "type": "object",
+        "properties": {
+          "argument": {"type": "number", "format": "double"},
+          "tenant": {"type": "string", "description": "Tenant owning the model; taken from the API key if empty."}
+        }
+      },
+      "linear_regression.SimpleRegressionModel": {
+        "type": "object",
+        "description": "f(x) = coefficient * x + intercept.",
+        "properties": {
+          "name": {"type": "string"},
+          "coefficient": {"type": "number", "format": "double"},
+          "intercept": {"type": "number", "format": "double"},
+          "dataFingerprint": {"type": "string", "description": "Hex SHA-256 of the stored model's training instances."}
+        }
+      },
+      "linear_regression.TrainingResults": {
+        "type": "object",
+        "properties": {
+          "model": {"$ref": "#/components/schemas/linear_regression.SimpleRegressionModel"},
+          "sumSquaredErrors": {"type": "number", "format": "double"},
+          "name": {"type": "string"},
+          "error": {"type": "string"},
+          "creationTime": {"type": "string", "format": "date-time"},
+          "droppedInstances": {"type": "string", "format": "int64"},
+          "clampedInstances": {"type": "string", "format": "int64"},
+          "invalidInstances": {"type": "array", "description": "Positions of the first invalid instances: line numbers of TSV, CSV and JSON Lines data, zero-based row numbers of Parquet and Arrow data, zero-based indices of JSON arrays and gRPC requests.", "items": {"type": "string", "format": "int64"}},
+          "dataFingerprint": {"type": "string"}
+        }
+      },
+      "linear_regression.ModelValue": {
+        "type": "object",
+        "properties": {
+          "value": {"type": "number", "format": "double"},
+          "argument": {"type": "number", "format": "double"},
+          "model": {"$ref": "#/components/schemas/linear_regression.SimpleRegressionModel"},
+          "fromCache": {"type": "boolean"},
+          "calculationTime": {"type": "string", "format": "date-time"},
+          "error": {"type": "string"}
+        }
+      },
+      "linear_regression.TrainingJob": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "state": {"type": "string", "enum": ["STATE_UNSPECIFIED", "QUEUED", "RUNNING", "SUCCEEDED", "FAILED", "CANCELLED"]},
+          "processedInstances": {"type": "string", "format": "int64"},
+          "totalInstances": {"type": "string", "format": "int64", "description": "Zero while unknown."},
+          "results": {"$ref": "#/components/schemas/linear_regression.TrainingResults"},
+          "error": {"type": "string"},
+          "createTime": {"type": "string", "format": "date-time"},
+          "startTime": {"type": "string", "format": "date-time"},
+          "finishTime": {"type": "string", "format": "date-time"}
+        }
+      },
+      "linear_regression.CancelJobRequest": {
+        "type": "object",
+        "properties": {
+          "tenant": {"type": "string", "description": "Tenant owning the job; taken from the API key if empty."}
+        }
+      },
+      "linear_regression.MethodStats": {
+        "type": "object",
+        "properties": {
+          "protocol": {"type": "string"},
+          "method": {"type": "string"},
+          "succeededRequests": {"type": "integer", "format": "int32"},
+          "totalRequests": {"type": "integer", "format": "int32"},
+          "totalInstances": {"type": "integer", "format": "int32"}
+        }
+      },
+      "linear_regression.WindowStats": {
+        "type": "object",
+        "properties": {
+          "window": {"type": "string"},
+          "totalRequests": {"type": "integer", "format": "int32"},
+          "failedRequests": {"type": "integer", "format": "int32"},
+          "requestRate": {"type": "number", "format": "double"},
+          "errorRate": {"type": "number", "format": "double"},
+          "latencyP50": {"type": "number", "format": "double"},
+          "latencyP95": {"type": "number", "format": "double"},
+          "latencyP99": {"type": "number", "format": "double"}
+        }
+      },
+      "linear_regression.TenantStats": {
+        "type": "object",
+        "properties": {
+          "tenant": {"type": "string"},
+          "succeededRequests": {"type": "integer", "format": "int32"},
+          "totalRequests": {"type": "integer", "format": "int32"},
+          "totalInstances": {"type": "integer", "format": "int32"}
+        }
+      },
+      "linear_regression.ServerStats": {
+        "type": "object",
+        "properties": {
+          "succeededRequests": {"type": "integer", "format": "int32"},
+          "totalRequests": {"type": "integer", "format": "int32"},
+          "totalInstances": {"type": "integer", "format": "int32"},
+          "cacheHits": {"type": "integer", "format": "int32"},
+          "cacheMisses": {"type": "integer", "format": "int32"},
+          "storageReads": {"type": "integer", "format": "int32"},
+          "storageWrites": {"type": "integer", "format": "int32"},
+          "methods": {"type": "array", "items": {"$ref": "#/components/schemas/linear_regression.MethodStats"}},
+          "windows": {"type": "array", "items": {"$ref": "#/components/schemas/linear_regression.WindowStats"}},
+          "tenants": {"type": "array", "items": {"$ref": "#/components/schemas/linear_regression.TenantStats"}}
+        }
+      }
+    }
+  }
+}
+`
+
+// handleOpenAPIRequest serves the OpenAPI document of the http API.
+func handleOpenAPIRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		reportStatusError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %v is not allowed", r.Method))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, openAPIDocument)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	pb "linear_regression_service/github.com/ashagraev/linear_regression"
+)
+
+type openAPITestDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components map[string]map[string]json.RawMessage `json:"components"`
+}
+
+func loadOpenAPIDocument(t *testing.T) *openAPITestDocument {
+	var document openAPITestDocument
+	if err := json.Unmarshal([]byte(openAPIDocument), &document); err != nil {
+		t.Fatalf("openapi document is not valid JSON: %v", err)
+	}
+	return &document
+}
+
+// muxRoutes returns the patterns newHTTPMux registers, read from its source.
+func muxRoutes(t *testing.T) []string {
+	file, err := parser.ParseFile(token.NewFileSet(), "http_handler.go", nil, 0)
+	if err != nil {
+		t.Fatalf("cannot parse http_handler.go: %v", err)
+	}
+	var routes []string
+	for _, decl := range file.Decls {
+		function, ok := decl.(*ast.FuncDecl)
+		if !ok || function.Name.Name != "newHTTPMux" {
+			continue
+		}
+		ast.Inspect(function.Body, func(node ast.Node) bool {
+			call, ok := node.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			selector, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || (selector.Sel.Name != "Handle" && selector.Sel.Name != "HandleFunc") {
+				return true
+			}
+			if literal, ok := call.Args[0].(*ast.BasicLit); ok && literal.Kind == token.STRING {
+				route, _ := strconv.Unquote(literal.Value)
+				routes = append(routes, route)
+			}
+			return true
+		})
+	}
+	if len(routes) == 0 {
+		t.Fatalf("no routes are found in newHTTPMux")
+	}
+	return routes
+}
+
+func TestOpenAPIDocumentDescribesMuxRoutes(t *testing.T) {
+	document := loadOpenAPIDocument(t)
+	for _, route := range muxRoutes(t) {
+		if strings.HasSuffix(route, "/") {
+			described := false
+			for path := range document.Paths {
+				described = described || strings.HasPrefix(path, route)
+			}
+			if !described {
+				t.Errorf("no path under %v is described", route)
+			}
+			continue
+		}
+		if _, ok := document.Paths[route]; !ok {
+			t.Errorf("route %v is not described", route)
+		}
+	}
+}
+
+func TestOpenAPIDocumentDescribesHTTPRules(t *testing.T) {
+	document := loadOpenAPIDocument(t)
+	ruled := map[string]bool{}
+	methods := pb.File_regression_proto.Services().ByName("Regression").Methods()
+	for i := 0; i < methods.Len(); i++ {
+		method := methods.Get(i)
+		rule, ok := proto.GetExtension(method.Options(), annotations.E_Http).(*annotations.HttpRule)
+		if !ok || rule == nil {
+			continue
+		}
+		for _, binding := range append([]*annotations.HttpRule{rule}, rule.AdditionalBindings...) {
+			httpMethod, path := httpRulePattern(binding)
+			ruled[path] = true
+			if _, ok := document.Paths[path][strings.ToLower(httpMethod)]; !ok {
+				t.Errorf("%v: %v %v is not described", method.Name(), httpMethod, path)
+			}
+		}
+	}
+
+	// The versioned paths are either transcoded or served by the mux itself.
+	routes := map[string]bool{}
+	for _, route := range muxRoutes(t) {
+		routes[route] = true
+	}
+	for path := range document.Paths {
+		if strings.HasPrefix(path, "/v1/") && !ruled[path] && !routes[path] {
+			t.Errorf("path %v is neither transcoded nor routed", path)
+		}
+	}
+}
+
+func TestOpenAPIDocumentReferences(t *testing.T) {
+	document := loadOpenAPIDocument(t)
+	var check func(value interface{})
+	check = func(value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				parts := strings.Split(strings.TrimPrefix(ref, "#/components/"), "/")
+				if _, found := document.Components[parts[0]][strings.Join(parts[1:], "/")]; len(parts) < 2 || !found {
+					t.Errorf("reference %v is not resolved", ref)
+				}
+			}
+			for _, item := range v {
+				check(item)
+			}
+		case []interface{}:
+			for _, item := range v {
+				check(item)
+			}
+		}
+	}
+	var root interface{}
+	json.Unmarshal([]byte(openAPIDocument), &root)
+	check(root)
+}
+
+func TestHandleOpenAPIRequestMethods(t *testing.T) {
+	for method, wantStatus := range map[string]int{http.MethodGet: http.StatusOK, http.MethodHead: http.StatusOK, http.MethodPost: http.StatusMethodNotAllowed} {
+		w := httptest.NewRecorder()
+		handleOpenAPIRequest(w, httptest.NewRequest(method, "/openapi.json", nil))
+		if w.Code != wantStatus {
+			t.Errorf("%v /openapi.json: status %v, want %v", method, w.Code, wantStatus)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache/lru"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/peer"
+)
+
+// limitsConfig stores the per-client rate limits and training quotas; zero values mean no limit.
+// The limits are enforced by every handler process on its own, they are not shared between the replicas.
+type limitsConfig struct {
+	// CalcRate limits the number of calculation requests per second, CalcBurst allows short bursts above it.
+	CalcRate  float64 `yaml:"calc_rate"`
+	CalcBurst int     `yaml:"calc_burst"`
+
+	// ModelsPerDay limits the number of models stored per UTC day.
+	ModelsPerDay int `yaml:"models_per_day"`
+}
+
+// maxTrackedClients limits the memory used by the calculation rate limiters: the least recently seen clients are forgotten.
+const maxTrackedClients = 10000
+
+var errRateLimited = errors.New("too many requests, retry later")
+
+// quotaError reports the exceeded quota; handlers answer 429 and ResourceExhausted on it.
+type quotaError struct {
+	message string
+}
+
+func (e *quotaError) Error() string {
+	return e.message
+}
+
+type dailyCounter struct {
+	count int
+}
+
+// clientLimiter enforces the limits per API key, or per client IP if authentication is disabled.
+type clientLimiter struct {
+	config *limitsConfig
+
+	calcLimiters *lru.Cache
+
+	// storedModels counts the models stored today by every client; it is not bounded by maxTrackedClients, as forgetting
+	// a counter would reset the client's quota, and is reset when the UTC day changes instead.
+	storedModels    map[string]*dailyCounter
+	storedModelsDay string
+
+	mutex sync.Mutex
+}
+
+func newClientLimiter(config *limitsConfig) *clientLimiter {
+	return &clientLimiter{
+		config:       config,
+		calcLimiters: lru.New(maxTrackedClients),
+		storedModels: map[string]*dailyCounter{},
+	}
+}
+
+// clientID identifies the client by its API key name or, for unauthenticated requests, by its IP address.
+func clientID(ctx context.Context, remoteAddr string) string {
+	if key := authenticatedKey(ctx); key != nil {
+		return "key:" + key.Name
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return "ip:" + host
+}
+
+// grpcClientID identifies the client of a gRPC call.
+func grpcClientID(ctx context.Context) string {
+	remoteAddr := ""
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
+	return clientID(ctx, remoteAddr)
+}
+
+// allowCalculation takes a token from the client's bucket.
+func (cl *clientLimiter) allowCalculation(client string) error {
+	if cl.config.CalcRate <= 0 {
+		return nil
+	}
+
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	var limiter *rate.Limiter
+	if cached, ok := cl.calcLimiters.Get(client); ok {
+		limiter = cached.(*rate.Limiter)
+	} else {
+		burst := cl.config.CalcBurst
+		if burst <= 0 {
+			burst = 1
+		}
+		limiter = rate.NewLimiter(rate.Limit(cl.config.CalcRate), burst)
+		cl.calcLimiters.Add(client, limiter)
+	}
+
+	if !limiter.Allow() {
+		return errRateLimited
+	}
+	return nil
+}
+
+func currentDay() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
+
+// quotaReservation holds one model of the client's daily quota while the model is trained: commit keeps it once the model
+// is stored, release returns it otherwise. A nil reservation (the quota is disabled) does nothing.
+type quotaReservation struct {
+	limiter *clientLimiter
+	counter *dailyCounter
+	done    bool
+}
+
+// reserveStoredModel takes one model of the client's daily quota, failing if the client has already stored or is storing
+// all the models allowed for today. Checking and taking the quota at once keeps concurrent requests within it.
+func (cl *clientLimiter) reserveStoredModel(client string) (*quotaReservation, error) {
+	if cl.config.ModelsPerDay <= 0 {
+		return nil, nil
+	}
+
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	day := currentDay()
+	if cl.storedModelsDay != day {
+		cl.storedModels = map[string]*dailyCounter{}
+		cl.storedModelsDay = day
+	}
+	counter, ok := cl.storedModels[client]
+	if !ok {
+		counter = &dailyCounter{}
+		cl.storedModels[client] = counter
+	}
+	if counter.count >= cl.config.ModelsPerDay {
+		return nil, &quotaError{fmt.Sprintf("daily quota of %v stored models is exhausted", cl.config.ModelsPerDay)}
+	}
+	counter.count++
+	return &quotaReservation{limiter: cl, counter: counter}, nil
+}
+
+// commit keeps the reserved model counted against the quota.
+func (qr *quotaReservation) commit() {
+	if qr == nil {
+		return
+	}
+	qr.limiter.mutex.Lock()
+	defer qr.limiter.mutex.Unlock()
+	qr.done = true
+}
+
+// release returns the reserved model to the quota unless the reservation is committed or already released.
+func (qr *quotaReservation) release() {
+	if qr == nil {
+		return
+	}
+	qr.limiter.mutex.Lock()
+	defer qr.limiter.mutex.Unlock()
+	if !qr.done {
+		qr.done = true
+		qr.counter.count--
+	}
+}
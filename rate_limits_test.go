@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestQuotaReservation(t *testing.T) {
+	tests := []struct {
+		name string
+		// finish commits or releases the first reservation, possibly several times.
+		finish       func(qr *quotaReservation)
+		wantReserved bool
+	}{
+		{"commit", func(qr *quotaReservation) { qr.commit() }, false},
+		{"release", func(qr *quotaReservation) { qr.release() }, true},
+		{"release twice", func(qr *quotaReservation) { qr.release(); qr.release() }, true},
+		{"commit then release", func(qr *quotaReservation) { qr.commit(); qr.release() }, false},
+		{"release then commit", func(qr *quotaReservation) { qr.release(); qr.commit() }, true},
+	}
+	for _, test := range tests {
+		cl := newClientLimiter(&limitsConfig{ModelsPerDay: 2})
+		first, err := cl.reserveStoredModel("ip:10.0.0.1")
+		if err != nil {
+			t.Fatalf("%v: first reservation failed: %v", test.name, err)
+		}
+		if _, err := cl.reserveStoredModel("ip:10.0.0.1"); err != nil {
+			t.Fatalf("%v: second reservation failed: %v", test.name, err)
+		}
+		_, err = cl.reserveStoredModel("ip:10.0.0.1")
+		var quotaErr *quotaError
+		if !errors.As(err, &quotaErr) {
+			t.Errorf("%v: reservation above the quota: %v, want a quota error", test.name, err)
+		}
+		if _, err := cl.reserveStoredModel("ip:10.0.0.2"); err != nil {
+			t.Errorf("%v: other client's reservation failed: %v", test.name, err)
+		}
+
+		test.finish(first)
+		if _, err := cl.reserveStoredModel("ip:10.0.0.1"); (err == nil) != test.wantReserved {
+			t.Errorf("%v: reservation after finishing the first one: %v, want success %v", test.name, err, test.wantReserved)
+		}
+	}
+}
+
+func TestQuotaReservationDisabled(t *testing.T) {
+	cl := newClientLimiter(&limitsConfig{})
+	for i := 0; i < 3; i++ {
+		qr, err := cl.reserveStoredModel("ip:10.0.0.1")
+		if err != nil || qr != nil {
+			t.Fatalf("reservation without a quota: %v, %v; want nil, nil", qr, err)
+		}
+		qr.commit()
+		qr.release()
+	}
+}
+
+func TestAllowCalculation(t *testing.T) {
+	cl := newClientLimiter(&limitsConfig{CalcRate: 0.001, CalcBurst: 2})
+	for i := 0; i < 2; i++ {
+		if err := cl.allowCalculation("key:reports"); err != nil {
+			t.Errorf("calculation #%v within the burst is limited: %v", i, err)
+		}
+	}
+	if err := cl.allowCalculation("key:reports"); err != errRateLimited {
+		t.Errorf("calculation above the burst: %v, want %v", err, errRateLimited)
+	}
+	if err := cl.allowCalculation("key:pipelines"); err != nil {
+		t.Errorf("other client's calculation is limited: %v", err)
+	}
+
+	unlimited := newClientLimiter(&limitsConfig{})
+	for i := 0; i < 10; i++ {
+		if err := unlimited.allowCalculation("key:reports"); err != nil {
+			t.Fatalf("calculation without a rate limit is limited: %v", err)
+		}
+	}
+}
+
+func TestClientID(t *testing.T) {
+	key := &apiKeyConfig{Name: "reports"}
+	tests := []struct {
+		name       string
+		key        *apiKeyConfig
+		remoteAddr string
+		want       string
+	}{
+		{"authenticated", key, "10.0.0.1:1234", "key:reports"},
+		{"ipv4", nil, "10.0.0.1:1234", "ip:10.0.0.1"},
+		{"ipv6", nil, "[::1]:1234", "ip:::1"},
+		{"no port", nil, "10.0.0.1", "ip:10.0.0.1"},
+	}
+	for _, test := range tests {
+		ctx := context.Background()
+		if test.key != nil {
+			ctx = context.WithValue(ctx, authContextKey{}, test.key)
+		}
+		if got := clientID(ctx, test.remoteAddr); got != test.want {
+			t.Errorf("%v: clientID() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	pb "linear_regression_service/github.com/ashagraev/linear_regression"
+)
+
+// responseFormat is the representation of the http responses chosen by the Accept header.
+type responseFormat int
+
+const (
+	// prettyJSONFormat is used by default, so that the responses stay readable in terminals and browsers.
+	prettyJSONFormat responseFormat = iota
+	compactJSONFormat
+	csvFormat
+	protobufFormat
+)
+
+var responseMediaTypes = map[string]responseFormat{
+	"application/json":       compactJSONFormat,
+	"text/csv":               csvFormat,
+	"application/x-protobuf": protobufFormat,
+	"application/protobuf":   protobufFormat,
+	"*/*":                    prettyJSONFormat,
+	"application/*":          compactJSONFormat,
+	"text/*":                 csvFormat,
+}
+
+// httpResponse is a result of an http request convertible to all the supported representations.
+type httpResponse interface {
+	// toProto converts the result to the message returned by the gRPC API.
+	toProto() proto.Message
+
+	// csvRecords returns the header and the rows of the CSV representation.
+	csvRecords() [][]string
+}
+
+// negotiateResponseFormat chooses the most preferred supported format of the Accept header;
+// ok is false if none of the accepted formats is supported.
+func negotiateResponseFormat(accept string) (format responseFormat, ok bool) {
+	if len(strings.TrimSpace(accept)) == 0 {
+		return prettyJSONFormat, true
+	}
+
+	bestQuality := 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		candidate, supported := responseMediaTypes[mediaType]
+		if !supported {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality > bestQuality {
+			format, bestQuality, ok = candidate, quality, true
+		}
+	}
+	return format, ok
+}
+
+// reportResponse writes the response in the format chosen by the request's Accept header.
+func reportResponse(w http.ResponseWriter, r *http.Request, response httpResponse, name string) {
+	reportResponseStatus(w, r, http.StatusOK, response, name)
+}
+
+// reportResponseStatus writes the response with the given status code.
+func reportResponseStatus(w http.ResponseWriter, r *http.Request, statusCode int, response httpResponse, name string) {
+	format, ok := negotiateResponseFormat(r.Header.Get("Accept"))
+	if !ok {
+		reportStatusError(w, http.StatusNotAcceptable, "not acceptable, use application/json, text/csv or application/x-protobuf")
+		return
+	}
+
+	var data []byte
+	var err error
+	contentType := "application/json"
+	switch format {
+	case prettyJSONFormat:
+		data, err = json.MarshalIndent(response, "", "    ")
+	case compactJSONFormat:
+		data, err = json.Marshal(response)
+	case csvFormat:
+		var buffer bytes.Buffer
+		err = csv.NewWriter(&buffer).WriteAll(response.csvRecords())
+		data, contentType = buffer.Bytes(), "text/csv"
+	case protobufFormat:
+		data, err = proto.Marshal(response.toProto())
+		contentType = "application/x-protobuf"
+	}
+	if err != nil {
+		reportFormatError(w, "could not marshal %v: %v", name, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+	w.Write(data)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// formatTime formats the moment as RFC 3339 in UTC with 0, 3, 6 or 9 fractional digits, exactly as the JSON mapping
+// of google.protobuf.Timestamp does, so that all the APIs and representations agree; zero time is formatted as an empty string.
+func formatTime(moment time.Time) string {
+	if moment.IsZero() {
+		return ""
+	}
+	layout := "2006-01-02T15:04:05.000000000Z"
+	switch nanos := moment.Nanosecond(); {
+	case nanos == 0:
+		layout = "2006-01-02T15:04:05Z"
+	case nanos % 1000000 == 0:
+		layout = "2006-01-02T15:04:05.000Z"
+	case nanos % 1000 == 0:
+		layout = "2006-01-02T15:04:05.000000Z"
+	}
+	return moment.UTC().Format(layout)
+}
+
+// timestampProto converts the moment to google.protobuf.Timestamp; zero time is converted to nil, so that the field is omitted.
+func timestampProto(moment time.Time) *timestamppb.Timestamp {
+	if moment.IsZero() {
+		return nil
+	}
+	return timestamppb.New(moment)
+}
+
+func (srm *SimpleRegressionModel) toProtoModel() *pb.SimpleRegressionModel {
+	return &pb.SimpleRegressionModel{
+		Name:            srm.Name,
+		Coefficient:     srm.Coefficient,
+		Intercept:       srm.Intercept,
+		DataFingerprint: srm.DataFingerprint,
+	}
+}
+
+func (srm *SimpleRegressionModel) toProto() proto.Message {
+	return srm.toProtoModel()
+}
+
+func (srm *SimpleRegressionModel) csvRecords() [][]string {
+	return [][]string{
+		{"name", "coefficient", "intercept", "data_fingerprint"},
+		{srm.Name, formatFloat(srm.Coefficient), formatFloat(srm.Intercept), srm.DataFingerprint},
+	}
+}
+
+// MarshalJSON formats the creation time with formatTime and omits it for the models which are not stored.
+func (tr *TrainingResults) MarshalJSON() ([]byte, error) {
+	type plainTrainingResults TrainingResults
+	return json.Marshal(&struct {
+		*plainTrainingResults
+		CreationTime string `json:"CreationTime,omitempty"`
+	}{(*plainTrainingResults)(tr), formatTime(tr.CreationTime)})
+}
+
+func (tr *TrainingResults) toProto() proto.Message {
+	result := &pb.TrainingResults{
+		Model:            tr.Model.toProtoModel(),
+		SumSquaredErrors: tr.SumSquaredErrors,
+		Name:             tr.Name,
+		Error:            tr.Error,
+		CreationTime:     timestampProto(tr.CreationTime),
+		DroppedInstances: int64(tr.DroppedInstances),
+		ClampedInstances: int64(tr.ClampedInstances),
+		DataFingerprint:  tr.DataFingerprint,
+	}
+	for _, idx := range tr.InvalidInstances {
+		result.InvalidInstances = append(result.InvalidInstances, int64(idx))
+	}
+	return result
+}
+
+func (tr *TrainingResults) csvRecords() [][]string {
+	return [][]string{
+		{"name", "coefficient", "intercept", "sum_squared_errors", "creation_time", "dropped_instances", "clamped_instances", "error", "data_fingerprint"},
+		{tr.Name, formatFloat(tr.Model.Coefficient), formatFloat(tr.Model.Intercept), formatFloat(tr.SumSquaredErrors),
+			formatTime(tr.CreationTime), strconv.Itoa(tr.DroppedInstances), strconv.Itoa(tr.ClampedInstances), tr.Error, tr.DataFingerprint},
+	}
+}
+
+// MarshalJSON formats the calculation time with formatTime.
+func (mv *ModelValue) MarshalJSON() ([]byte, error) {
+	type plainModelValue ModelValue
+	return json.Marshal(&struct {
+		*plainModelValue
+		CalculationTime string `json:",omitempty"`
+	}{(*plainModelValue)(mv), formatTime(mv.CalculationTime)})
+}
+
+func (mv *ModelValue) toProto() proto.Message {
+	return &pb.ModelValue{
+		Value:           mv.Value,
+		Argument:        mv.Argument,
+		Model:           mv.Model.toProtoModel(),
+		FromCache:       mv.FromCache,
+		CalculationTime: timestampProto(mv.CalculationTime),
+	}
+}
+
+func (mv *ModelValue) csvRecords() [][]string {
+	return [][]string{
+		{"model", "argument", "value", "coefficient", "intercept", "from_cache", "calculation_time"},
+		{mv.Model.Name, formatFloat(mv.Argument), formatFloat(mv.Value), formatFloat(mv.Model.Coefficient),
+			formatFloat(mv.Model.Intercept), strconv.FormatBool(mv.FromCache), formatTime(mv.CalculationTime)},
+	}
+}
+
+func (stats *ExecutionStats) toProto() proto.Message {
+	return statsToProto(*stats)
+}
+
+// csvRecords lists the per-method counters preceded by the totals.
+func (stats *ExecutionStats) csvRecords() [][]string {
+	records := [][]string{
+		{"protocol", "method", "succeeded_requests", "total_requests", "total_instances"},
+		{"all", "all", strconv.Itoa(stats.SucceededRequests), strconv.Itoa(stats.TotalRequests), strconv.Itoa(stats.TotalInstances)},
+	}
+	for _, methodStats := range stats.Methods {
+		records = append(records, []string{methodStats.Protocol, methodStats.Method, strconv.Itoa(methodStats.SucceededRequests),
+			strconv.Itoa(methodStats.TotalRequests), strconv.Itoa(methodStats.TotalInstances)})
+	}
+	return records
+}
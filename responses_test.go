@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestNegotiateResponseFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   responseFormat
+		wantOK bool
+	}{
+		{"", prettyJSONFormat, true},
+		{"*/*", prettyJSONFormat, true},
+		{"application/json", compactJSONFormat, true},
+		{"text/csv", csvFormat, true},
+		{"application/x-protobuf", protobufFormat, true},
+		{"text/csv;q=0.5, application/json", compactJSONFormat, true},
+		{"text/csv;q=0.9, application/json;q=0.1", csvFormat, true},
+		{"image/png, text/csv", csvFormat, true},
+		{"text/html;q=bad, text/*", csvFormat, true},
+		{"image/png", 0, false},
+	}
+	for _, test := range tests {
+		format, ok := negotiateResponseFormat(test.accept)
+		if ok != test.wantOK || (ok && format != test.want) {
+			t.Errorf("negotiateResponseFormat(%q) = %v, %v; want %v, %v", test.accept, format, ok, test.want, test.wantOK)
+		}
+	}
+}
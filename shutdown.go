@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownSignals returns a channel receiving the signals which make the handlers stop.
+func shutdownSignals() <-chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	return signals
+}
+
+// releaseHandlerResources stops the training jobs, letting the running ones finish until the shutdown deadline,
+// flushes the execution statistics and closes the models storage. It must be called after all the in-flight requests are drained.
+func releaseHandlerResources(stats *statsCollector, jobs *jobsManager, ms *modelsStorage, deadline time.Time) {
+	jobs.close(deadline)
+
+	finalStats, err := json.Marshal(stats.flush())
+	if err != nil {
+		log.Printf("could not marshal final stats: %v", err)
+	} else {
+		log.Printf("final execution stats: %s", finalStats)
+	}
+
+	ms.close()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// defaultTenant owns the models of the requests which do not choose a tenant.
+const defaultTenant = "default"
+
+var tenantNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// errTenantForbidden is returned when the request addresses a tenant its API key does not belong to.
+var errTenantForbidden = errors.New("API key does not grant access to the tenant")
+
+// resolveTenant chooses the tenant serving the request. Non-admin API keys may only address their own tenant, the keys
+// without a tenant being bound to defaultTenant; admin keys and unauthenticated requests (if authentication is disabled)
+// may address any tenant.
+func resolveTenant(ctx context.Context, requested string) (string, error) {
+	tenant := requested
+	if key := authenticatedKey(ctx); key != nil {
+		keyTenant := key.Tenant
+		if len(keyTenant) == 0 && !key.allows(adminScope) {
+			keyTenant = defaultTenant
+		}
+		if len(requested) > 0 && len(keyTenant) > 0 && requested != keyTenant && !key.allows(adminScope) {
+			return "", errTenantForbidden
+		}
+		if len(requested) == 0 {
+			tenant = keyTenant
+		}
+	}
+
+	if len(tenant) == 0 {
+		return defaultTenant, nil
+	}
+	if !tenantNameRegexp.MatchString(tenant) {
+		return "", fmt.Errorf("invalid tenant name: %q", tenant)
+	}
+	return tenant, nil
+}
+
+// statsTenantFilter returns the only tenant whose stats are visible to the request, empty if all the tenants are.
+func statsTenantFilter(ctx context.Context) string {
+	if key := authenticatedKey(ctx); key != nil && !key.allows(adminScope) {
+		if len(key.Tenant) > 0 {
+			return key.Tenant
+		}
+		return defaultTenant
+	}
+	return ""
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestResolveTenant(t *testing.T) {
+	tenantKey := &apiKeyConfig{Name: "pipelines", Scopes: []authScope{trainScope}, Tenant: "pipelines"}
+	tenantlessKey := &apiKeyConfig{Name: "reports", Scopes: []authScope{trainScope}}
+	adminKey := &apiKeyConfig{Name: "ops", Scopes: []authScope{adminScope}}
+
+	tests := []struct {
+		name      string
+		key       *apiKeyConfig
+		requested string
+		want      string
+		wantErr   bool
+	}{
+		{"unauthenticated default", nil, "", defaultTenant, false},
+		{"unauthenticated any tenant", nil, "acme", "acme", false},
+		{"invalid tenant name", nil, "a/b", "", true},
+		{"tenant key default", tenantKey, "", "pipelines", false},
+		{"tenant key own tenant", tenantKey, "pipelines", "pipelines", false},
+		{"tenant key other tenant", tenantKey, "acme", "", true},
+		{"tenantless key default", tenantlessKey, "", defaultTenant, false},
+		{"tenantless key default tenant", tenantlessKey, defaultTenant, defaultTenant, false},
+		{"tenantless key other tenant", tenantlessKey, "acme", "", true},
+		{"admin key any tenant", adminKey, "acme", "acme", false},
+		{"admin key default", adminKey, "", defaultTenant, false},
+	}
+	for _, test := range tests {
+		ctx := context.Background()
+		if test.key != nil {
+			ctx = context.WithValue(ctx, authContextKey{}, test.key)
+		}
+		tenant, err := resolveTenant(ctx, test.requested)
+		if (err != nil) != test.wantErr || tenant != test.want {
+			t.Errorf("%v: resolveTenant() = %q, %v; want %q, error %v", test.name, tenant, err, test.want, test.wantErr)
+		}
+	}
+}
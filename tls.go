@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// serverTLSConfig stores the handler's certificate and the settings of the client certificates verification.
+type serverTLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// CAFile stores the certificate authorities used to verify the client certificates.
+	CAFile string `yaml:"ca_file"`
+
+	// ClientAuth makes the handler require and verify the client certificates (mutual TLS).
+	ClientAuth bool `yaml:"client_auth"`
+}
+
+// clientTLSConfig stores the settings the clients use to verify the handler and to identify themselves.
+type clientTLSConfig struct {
+	// Enabled turns TLS on for gRPC connections; http connections use TLS for https:// servers.
+	Enabled bool `yaml:"enabled"`
+
+	// CAFile stores the certificate authorities used to verify the handler's certificate; system ones are used if empty.
+	CAFile     string `yaml:"ca_file"`
+	ServerName string `yaml:"server_name"`
+
+	// CertFile and KeyFile store the client certificate for mutual TLS.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %v", caFile)
+	}
+	return pool, nil
+}
+
+func (c *serverTLSConfig) enabled() bool {
+	return len(c.CertFile) > 0 || len(c.KeyFile) > 0
+}
+
+// newTLSConfig returns nil if TLS is not configured for the handler.
+func (c *serverTLSConfig) newTLSConfig() (*tls.Config, error) {
+	if !c.enabled() {
+		if c.ClientAuth {
+			return nil, errors.New("client certificates verification requires TLS (--tls-cert and --tls-key)")
+		}
+		return nil, nil
+	}
+	if len(c.CertFile) == 0 || len(c.KeyFile) == 0 {
+		return nil, errors.New("choose both the certificate (--tls-cert) and the key (--tls-key)")
+	}
+
+	certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load certificate: %v", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if len(c.CAFile) > 0 {
+		if config.ClientCAs, err = loadCertPool(c.CAFile); err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if c.ClientAuth {
+		if config.ClientCAs == nil {
+			return nil, errors.New("client certificates verification requires the CA file (--tls-ca)")
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+func (c *clientTLSConfig) enabled() bool {
+	return c.Enabled || len(c.CAFile) > 0 || len(c.CertFile) > 0 || len(c.KeyFile) > 0
+}
+
+func (c *clientTLSConfig) newTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: c.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if len(c.CAFile) > 0 {
+		pool, err := loadCertPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if len(c.CertFile) > 0 || len(c.KeyFile) > 0 {
+		if len(c.CertFile) == 0 || len(c.KeyFile) == 0 {
+			return nil, errors.New("choose both the client certificate (--tls-cert) and the key (--tls-key)")
+		}
+		certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
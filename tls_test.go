@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed certificate valid for localhost and its key to dir,
+// returning the files' paths; the certificate is its own CA.
+func writeTestCertificate(t *testing.T, dir string, name string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("cannot create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("cannot marshal key: %v", err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, name + ".crt"), filepath.Join(dir, name + ".key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir, "server")
+	notPEM := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(notPEM, []byte("no certificates"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		config         serverTLSConfig
+		wantErr        bool
+		wantNil        bool
+		wantClientAuth tls.ClientAuthType
+	}{
+		{"disabled", serverTLSConfig{}, false, true, tls.NoClientCert},
+		{"client auth without TLS", serverTLSConfig{ClientAuth: true}, true, false, 0},
+		{"missing key", serverTLSConfig{CertFile: certFile}, true, false, 0},
+		{"missing certificate", serverTLSConfig{KeyFile: keyFile}, true, false, 0},
+		{"mismatched files", serverTLSConfig{CertFile: keyFile, KeyFile: certFile}, true, false, 0},
+		{"server only", serverTLSConfig{CertFile: certFile, KeyFile: keyFile}, false, false, tls.NoClientCert},
+		{"optional client certificates", serverTLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: certFile}, false, false, tls.VerifyClientCertIfGiven},
+		{"mutual TLS", serverTLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: certFile, ClientAuth: true}, false, false, tls.RequireAndVerifyClientCert},
+		{"mutual TLS without CA", serverTLSConfig{CertFile: certFile, KeyFile: keyFile, ClientAuth: true}, true, false, 0},
+		{"CA without certificates", serverTLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: notPEM}, true, false, 0},
+		{"missing CA file", serverTLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: filepath.Join(dir, "missing.pem")}, true, false, 0},
+	}
+	for _, test := range tests {
+		config, err := test.config.newTLSConfig()
+		if (err != nil) != test.wantErr {
+			t.Errorf("%v: newTLSConfig() error %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if (config == nil) != test.wantNil {
+			t.Errorf("%v: newTLSConfig() = %v, want nil %v", test.name, config, test.wantNil)
+			continue
+		}
+		if config == nil {
+			continue
+		}
+		if config.ClientAuth != test.wantClientAuth {
+			t.Errorf("%v: client auth %v, want %v", test.name, config.ClientAuth, test.wantClientAuth)
+		}
+		if config.MinVersion != tls.VersionTLS12 {
+			t.Errorf("%v: min version %x, want TLS 1.2", test.name, config.MinVersion)
+		}
+	}
+}
+
+func TestClientTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir, "client")
+
+	tests := []struct {
+		name             string
+		config           clientTLSConfig
+		wantEnabled      bool
+		wantErr          bool
+		wantRoots        bool
+		wantCertificates int
+	}{
+		{"disabled", clientTLSConfig{}, false, false, false, 0},
+		{"system roots", clientTLSConfig{Enabled: true}, true, false, false, 0},
+		{"custom CA", clientTLSConfig{CAFile: certFile}, true, false, true, 0},
+		{"client certificate", clientTLSConfig{CAFile: certFile, CertFile: certFile, KeyFile: keyFile}, true, false, true, 1},
+		{"missing key", clientTLSConfig{CertFile: certFile}, true, true, false, 0},
+		{"missing CA file", clientTLSConfig{CAFile: filepath.Join(dir, "missing.pem")}, true, true, false, 0},
+	}
+	for _, test := range tests {
+		if test.config.enabled() != test.wantEnabled {
+			t.Errorf("%v: enabled() = %v, want %v", test.name, test.config.enabled(), test.wantEnabled)
+		}
+		config, err := test.config.newTLSConfig()
+		if (err != nil) != test.wantErr {
+			t.Errorf("%v: newTLSConfig() error %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if (config.RootCAs != nil) != test.wantRoots {
+			t.Errorf("%v: custom roots %v, want %v", test.name, config.RootCAs != nil, test.wantRoots)
+		}
+		if len(config.Certificates) != test.wantCertificates {
+			t.Errorf("%v: %v client certificates, want %v", test.name, len(config.Certificates), test.wantCertificates)
+		}
+	}
+}
+
+func TestMutualTLSHandshake(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, serverKey := writeTestCertificate(t, dir, "server")
+	clientCert, clientKey := writeTestCertificate(t, dir, "client")
+
+	serverConfig, err := (&serverTLSConfig{CertFile: serverCert, KeyFile: serverKey, CAFile: clientCert, ClientAuth: true}).newTLSConfig()
+	if err != nil {
+		t.Fatalf("server newTLSConfig() error: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = serverConfig
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		config  clientTLSConfig
+		wantErr bool
+	}{
+		{"client certificate", clientTLSConfig{CAFile: serverCert, ServerName: "localhost", CertFile: clientCert, KeyFile: clientKey}, false},
+		{"no client certificate", clientTLSConfig{CAFile: serverCert, ServerName: "localhost"}, true},
+		{"unknown server", clientTLSConfig{CAFile: clientCert, ServerName: "localhost", CertFile: clientCert, KeyFile: clientKey}, true},
+	}
+	for _, test := range tests {
+		clientConfig, err := test.config.newTLSConfig()
+		if err != nil {
+			t.Fatalf("%v: client newTLSConfig() error: %v", test.name, err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+		response, err := client.Get(server.URL)
+		if err == nil {
+			response.Body.Close()
+		}
+		if (err != nil) != test.wantErr {
+			t.Errorf("%v: request error %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/utilities"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	pb "linear_regression_service/github.com/ashagraev/linear_regression"
+)
+
+// protobufMarshaler serves application/x-protobuf, the same media type the hand-written endpoints use.
+type protobufMarshaler struct {
+	runtime.ProtoMarshaller
+}
+
+func (*protobufMarshaler) ContentType(interface{}) string {
+	return "application/x-protobuf"
+}
+
+// transcodedMethod is a Regression service's method bound to an http route by its google.api.http option.
+type transcodedMethod struct {
+	fullName string
+	body     string
+
+	// call is the method's function of pb.RegressionService.
+	call reflect.Value
+}
+
+// httpRulePattern returns the http method and the path template of the binding.
+func httpRulePattern(rule *annotations.HttpRule) (string, string) {
+	switch pattern := rule.Pattern.(type) {
+	case *annotations.HttpRule_Get:
+		return http.MethodGet, pattern.Get
+	case *annotations.HttpRule_Put:
+		return http.MethodPut, pattern.Put
+	case *annotations.HttpRule_Post:
+		return http.MethodPost, pattern.Post
+	case *annotations.HttpRule_Delete:
+		return http.MethodDelete, pattern.Delete
+	case *annotations.HttpRule_Patch:
+		return http.MethodPatch, pattern.Patch
+	case *annotations.HttpRule_Custom:
+		return pattern.Custom.Kind, pattern.Custom.Path
+	}
+	return "", ""
+}
+
+// reportRoutingError answers 405 for the known paths requested with other methods; the gateway answers 501 by default.
+func reportRoutingError(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, httpStatus int) {
+	if httpStatus == http.StatusMethodNotAllowed {
+		err := status.Error(codes.Unimplemented, http.StatusText(httpStatus))
+		runtime.HTTPError(ctx, mux, marshaler, w, r, &runtime.HTTPStatusError{HTTPStatus: httpStatus, Err: err})
+		return
+	}
+	runtime.DefaultRoutingErrorHandler(ctx, mux, marshaler, w, r, httpStatus)
+}
+
+// reportTranscodedError answers 422 for FAILED_PRECONDITION, as the upload endpoints do for the idempotency keys
+// reused with different training data; the other codes are mapped as the gateway does by default.
+func reportTranscodedError(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	if status.Code(err) == codes.FailedPrecondition {
+		err = &runtime.HTTPStatusError{HTTPStatus: http.StatusUnprocessableEntity, Err: err}
+	}
+	runtime.DefaultHTTPErrorHandler(ctx, mux, marshaler, w, r, err)
+}
+
+// newTranscodingHandler serves the JSON/HTTP API declared by the google.api.http options of regression.proto,
+// grpc-gateway style: the requests are transcoded to the Regression service's messages and passed to the gRPC
+// handler's methods in-process, so that both protocols share the same contract, authorization and validation.
+func newTranscodingHandler(h *grpcHandler) (*runtime.ServeMux, error) {
+	mux := runtime.NewServeMux(
+		runtime.WithMarshalerOption("application/x-protobuf", &protobufMarshaler{}),
+		runtime.WithRoutingErrorHandler(reportRoutingError),
+		runtime.WithErrorHandler(reportTranscodedError),
+	)
+
+	svc := reflect.ValueOf(h.Svc()).Elem()
+	methods := pb.File_regression_proto.Services().ByName("Regression").Methods()
+	for i := 0; i < methods.Len(); i++ {
+		method := methods.Get(i)
+		rule, ok := proto.GetExtension(method.Options(), annotations.E_Http).(*annotations.HttpRule)
+		if !ok || rule == nil {
+			continue
+		}
+		call := svc.FieldByName(string(method.Name()))
+		if !call.IsValid() || call.IsNil() {
+			return nil, fmt.Errorf("method %v is not implemented", method.FullName())
+		}
+
+		for _, binding := range append([]*annotations.HttpRule{rule}, rule.AdditionalBindings...) {
+			httpMethod, path := httpRulePattern(binding)
+			if binding.Body != "" && binding.Body != "*" {
+				return nil, fmt.Errorf("method %v: only the whole request can be bound to the body", method.FullName())
+			}
+			transcoded := &transcodedMethod{
+				fullName: fmt.Sprintf("/%v/%v", method.Parent().FullName(), method.Name()),
+				body:     binding.Body,
+				call:     call,
+			}
+			if err := mux.HandlePath(httpMethod, path, h.transcode(mux, transcoded)); err != nil {
+				return nil, fmt.Errorf("cannot bind method %v to %v %v: %v", method.FullName(), httpMethod, path, err)
+			}
+		}
+	}
+	return mux, nil
+}
+
+// remoteAddr converts the http client's address for the rate limits keyed by the gRPC peers.
+func remoteAddr(address string) net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil
+	}
+	return addr
+}
+
+// decodeRequest fills the method's request from the body, the query string and the path parameters.
+func (m *transcodedMethod) decodeRequest(marshaler runtime.Marshaler, r *http.Request, pathParams map[string]string) (proto.Message, error) {
+	request := reflect.New(m.call.Type().In(1).Elem()).Interface().(proto.Message)
+
+	if m.body == "*" {
+		if err := marshaler.NewDecoder(r.Body).Decode(request); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("could not decode request body: %v", err)
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		// The fields bound to the path are not taken from the query string.
+		var pathFields [][]string
+		for field := range pathParams {
+			pathFields = append(pathFields, strings.Split(field, "."))
+		}
+		if err := runtime.PopulateQueryParameters(request, r.Form, utilities.NewDoubleArray(pathFields)); err != nil {
+			return nil, err
+		}
+	}
+
+	for field, value := range pathParams {
+		if err := runtime.PopulateFieldFromPath(request, field, value); err != nil {
+			return nil, fmt.Errorf("invalid %v: %v", field, err)
+		}
+	}
+	return request, nil
+}
+
+// transcode serves a single binding: it authorizes the request the same way the gRPC interceptors do,
+// calls the method and writes its response or its status.
+func (h *grpcHandler) transcode(mux *runtime.ServeMux, method *transcodedMethod) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		inbound, outbound := runtime.MarshalerForRequest(mux, r)
+
+		ctx := metadata.NewIncomingContext(r.Context(), metadata.Pairs(
+			"authorization", r.Header.Get("Authorization"),
+			"x-api-key", r.Header.Get("X-API-Key"),
+		))
+		if addr := remoteAddr(r.RemoteAddr); addr != nil {
+			ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+		}
+		ctx, err := h.authorize(ctx, method.fullName)
+		if err != nil {
+			runtime.HTTPError(r.Context(), mux, outbound, w, r, err)
+			return
+		}
+		ctx, cancel := withRequestTimeout(ctx, h.config)
+		defer cancel()
+
+		r.Body = http.MaxBytesReader(w, r.Body, h.config.MaxRequestBytes)
+		request, err := method.decodeRequest(inbound, r, pathParams)
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, status.Error(codes.InvalidArgument, err.Error()))
+			return
+		}
+
+		results := method.call.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(request)})
+		if err, _ := results[1].Interface().(error); err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+		runtime.ForwardResponseMessage(ctx, mux, outbound, w, r, results[0].Interface().(proto.Message))
+	}
+}
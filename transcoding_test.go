@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTranscodingHandler(t *testing.T) {
+	config := defaultServiceConfig().Handler
+	config.MaxInstances = 3
+	config.Auth = testAuthConfig
+	h := newTestHTTPHandler(t, &config)
+
+	const instances = `{"instances": [{"argument": 1, "target": 3, "weight": 1}, {"argument": 2, "target": 5, "weight": 1}]}`
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		apiKey     string
+		wantStatus int
+	}{
+		{"train", http.MethodPost, "/v1/models:train", instances, "train-key", http.StatusOK},
+		{"no credential", http.MethodPost, "/v1/models:train", instances, "", http.StatusUnauthorized},
+		{"missing scope", http.MethodPost, "/v1/models:train", instances, "calc-key", http.StatusForbidden},
+		{"no instances", http.MethodPost, "/v1/models:train", `{}`, "train-key", http.StatusBadRequest},
+		{"malformed body", http.MethodPost, "/v1/models:train", `{"instances": [`, "train-key", http.StatusBadRequest},
+		{"unknown field", http.MethodPost, "/v1/models:train", `{"points": []}`, "train-key", http.StatusBadRequest},
+		{"too many instances", http.MethodPost, "/v1/models:train", `{"instances": [{}, {}, {}, {}]}`, "train-key", http.StatusTooManyRequests},
+		{"wrong method", http.MethodGet, "/v1/models:train", "", "train-key", http.StatusMethodNotAllowed},
+		{"unknown path", http.MethodGet, "/v1/unknown", "", "train-key", http.StatusNotFound},
+		{"missing job", http.MethodGet, "/v1/jobs/missing", "", "train-key", http.StatusNotFound},
+		{"cancel missing job", http.MethodPost, "/v1/jobs/missing:cancel", `{}`, "admin-key", http.StatusNotFound},
+		{"stats", http.MethodGet, "/v1/stats", "", "calc-key", http.StatusOK},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		r.Header.Set("Content-Type", "application/json")
+		if len(test.apiKey) > 0 {
+			r.Header.Set("X-API-Key", test.apiKey)
+		}
+		w := httptest.NewRecorder()
+		h.transcoder.ServeHTTP(w, r)
+		if w.Code != test.wantStatus {
+			t.Errorf("%v: status %v (%v), want %v", test.name, w.Code, strings.TrimSpace(w.Body.String()), test.wantStatus)
+		}
+	}
+}
+
+func TestReportTranscodedError(t *testing.T) {
+	config := defaultServiceConfig().Handler
+	h := newTestHTTPHandler(t, &config)
+
+	tests := []struct {
+		code       codes.Code
+		wantStatus int
+	}{
+		{codes.FailedPrecondition, http.StatusUnprocessableEntity},
+		{codes.InvalidArgument, http.StatusBadRequest},
+		{codes.NotFound, http.StatusNotFound},
+		{codes.ResourceExhausted, http.StatusTooManyRequests},
+		{codes.Unavailable, http.StatusServiceUnavailable},
+		{codes.Internal, http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/v1/models:train", nil)
+		w := httptest.NewRecorder()
+		reportTranscodedError(context.Background(), h.transcoder, &protobufMarshaler{}, w, r, status.Error(test.code, "failed"))
+		if w.Code != test.wantStatus {
+			t.Errorf("%v: status %v, want %v", test.code, w.Code, test.wantStatus)
+		}
+	}
+}
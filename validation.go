@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// validationPolicy chooses how the invalid training instances are handled.
+type validationPolicy string
+
+const (
+	// rejectPolicy fails the whole training request on the first invalid instance.
+	rejectPolicy validationPolicy = "reject"
+
+	// skipPolicy drops the invalid instances and trains on the rest.
+	skipPolicy validationPolicy = "skip"
+
+	// clampPolicy replaces negative weights with zero; instances with NaN or infinite values can not be fixed
+	// and are dropped as with skipPolicy.
+	clampPolicy validationPolicy = "clamp"
+)
+
+// maxReportedInstances limits the number of invalid instances listed in the training results.
+const maxReportedInstances = 10
+
+func (p validationPolicy) validate() error {
+	switch p {
+	case rejectPolicy, skipPolicy, clampPolicy:
+		return nil
+	}
+	return fmt.Errorf("unknown invalid instances policy %q, use reject, skip or clamp", p)
+}
+
+// String and Set make the policy usable as a command line flag.
+func (p *validationPolicy) String() string {
+	return string(*p)
+}
+
+func (p *validationPolicy) Set(value string) error {
+	policy := validationPolicy(value)
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	*p = policy
+	return nil
+}
+
+// instanceValidator checks the training instances one by one and collects the validation report.
+type instanceValidator struct {
+	policy validationPolicy
+
+	droppedInstances int
+	clampedInstances int
+
+	// invalidInstances stores the positions of the first invalid instances in the source: the line numbers
+	// of the delimited and JSON Lines data, the zero-based row numbers of the columnar data and the indices otherwise.
+	invalidInstances []int
+}
+
+func newInstanceValidator(policy validationPolicy) *instanceValidator {
+	return &instanceValidator{policy: policy}
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func (v *instanceValidator) reportInvalid(position int) {
+	if len(v.invalidInstances) < maxReportedInstances {
+		v.invalidInstances = append(v.invalidInstances, position)
+	}
+}
+
+// check validates the instance at the given position and returns the weight it should be trained with;
+// ok is false if the instance must be dropped.
+func (v *instanceValidator) check(position int, feature float64, target float64, weight float64) (float64, bool, error) {
+	var problem string
+	switch {
+	case !finite(feature):
+		problem = fmt.Sprintf("feature is %v", feature)
+	case !finite(target):
+		problem = fmt.Sprintf("target is %v", target)
+	case !finite(weight):
+		problem = fmt.Sprintf("weight is %v", weight)
+	case weight < 0:
+		problem = fmt.Sprintf("weight is negative: %v", weight)
+	default:
+		return weight, true, nil
+	}
+
+	if v.policy == rejectPolicy {
+		return 0, false, fmt.Errorf("invalid instance #%v: %v", position, problem)
+	}
+	v.reportInvalid(position)
+	if v.policy == clampPolicy && finite(weight) && weight < 0 {
+		v.clampedInstances++
+		return 0, true, nil
+	}
+	v.droppedInstances++
+	return 0, false, nil
+}
+
+// fill copies the validation report into the training results.
+func (v *instanceValidator) fill(results *TrainingResults) {
+	results.DroppedInstances = v.droppedInstances
+	results.ClampedInstances = v.clampedInstances
+	results.InvalidInstances = v.invalidInstances
+}
+
+func (v *instanceValidator) String() string {
+	return fmt.Sprintf("dropped %v and clamped %v invalid instances, first invalid instances: %v",
+		v.droppedInstances, v.clampedInstances, v.invalidInstances)
+}
+
+// checkTrainedModel prevents storing the models with NaN or infinite parameters.
+func checkTrainedModel(model *SimpleRegressionModel) error {
+	if !finite(model.Coefficient) || !finite(model.Intercept) {
+		return fmt.Errorf("training produced an invalid model: coefficient %v, intercept %v", model.Coefficient, model.Intercept)
+	}
+	return nil
+}
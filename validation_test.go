@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInstanceValidatorAcceptsValidInstances(t *testing.T) {
+	for _, policy := range []validationPolicy{rejectPolicy, skipPolicy, clampPolicy} {
+		validator := newInstanceValidator(policy)
+		weight, ok, err := validator.check(0, 1, 2, 0.5)
+		if err != nil || !ok || weight != 0.5 {
+			t.Errorf("%v: check() = %v, %v, %v; want 0.5, true, nil", policy, weight, ok, err)
+		}
+		if validator.droppedInstances != 0 || validator.clampedInstances != 0 || len(validator.invalidInstances) != 0 {
+			t.Errorf("%v: valid instance is reported as invalid: %v", policy, validator)
+		}
+	}
+}
+
+func TestInstanceValidatorPolicies(t *testing.T) {
+	tests := []struct {
+		name                     string
+		policy                   validationPolicy
+		feature, target, weight  float64
+		wantWeight               float64
+		wantOK, wantErr          bool
+		wantDropped, wantClamped int
+	}{
+		{"reject NaN feature", rejectPolicy, math.NaN(), 1, 1, 0, false, true, 0, 0},
+		{"reject infinite target", rejectPolicy, 1, math.Inf(1), 1, 0, false, true, 0, 0},
+		{"reject negative weight", rejectPolicy, 1, 1, -1, 0, false, true, 0, 0},
+		{"skip NaN weight", skipPolicy, 1, 1, math.NaN(), 0, false, false, 1, 0},
+		{"skip negative weight", skipPolicy, 1, 1, -1, 0, false, false, 1, 0},
+		{"clamp negative weight", clampPolicy, 1, 1, -1, 0, true, false, 0, 1},
+		{"clamp drops infinite weight", clampPolicy, 1, 1, math.Inf(-1), 0, false, false, 1, 0},
+		{"clamp drops NaN target", clampPolicy, 1, math.NaN(), 1, 0, false, false, 1, 0},
+	}
+	for _, test := range tests {
+		validator := newInstanceValidator(test.policy)
+		weight, ok, err := validator.check(3, test.feature, test.target, test.weight)
+		if (err != nil) != test.wantErr || ok != test.wantOK || weight != test.wantWeight {
+			t.Errorf("%v: check() = %v, %v, %v; want %v, %v, error %v", test.name, weight, ok, err, test.wantWeight, test.wantOK, test.wantErr)
+		}
+		if validator.droppedInstances != test.wantDropped || validator.clampedInstances != test.wantClamped {
+			t.Errorf("%v: dropped %v and clamped %v instances, want %v and %v", test.name,
+				validator.droppedInstances, validator.clampedInstances, test.wantDropped, test.wantClamped)
+		}
+		if !test.wantErr && !reflect.DeepEqual(validator.invalidInstances, []int{3}) {
+			t.Errorf("%v: invalid instances %v, want [3]", test.name, validator.invalidInstances)
+		}
+	}
+}
+
+func TestInstanceValidatorReportsFirstInvalidInstances(t *testing.T) {
+	validator := newInstanceValidator(skipPolicy)
+	for idx := 0; idx < 2 * maxReportedInstances; idx++ {
+		validator.check(idx, math.NaN(), 1, 1)
+	}
+
+	var results TrainingResults
+	validator.fill(&results)
+	if results.DroppedInstances != 2 * maxReportedInstances {
+		t.Errorf("dropped %v instances, want %v", results.DroppedInstances, 2 * maxReportedInstances)
+	}
+	if len(results.InvalidInstances) != maxReportedInstances || results.InvalidInstances[0] != 0 {
+		t.Errorf("invalid instances %v, want the first %v", results.InvalidInstances, maxReportedInstances)
+	}
+}
+
+func TestValidationPolicySet(t *testing.T) {
+	var policy validationPolicy
+	if err := policy.Set("clamp"); err != nil || policy != clampPolicy {
+		t.Errorf("Set(clamp) = %v, policy %v", err, policy)
+	}
+	if err := policy.Set("ignore"); err == nil || policy != clampPolicy {
+		t.Errorf("Set(ignore) = %v, policy %v; want an error and the policy unchanged", err, policy)
+	}
+}
+
+func TestCheckTrainedModel(t *testing.T) {
+	if err := checkTrainedModel(&SimpleRegressionModel{Coefficient: 1, Intercept: 2}); err != nil {
+		t.Errorf("finite model is rejected: %v", err)
+	}
+	if err := checkTrainedModel(&SimpleRegressionModel{Coefficient: math.NaN()}); err == nil {
+		t.Errorf("NaN model is accepted")
+	}
+}
+
+func TestTrainInstancesRequiresAcceptedInstances(t *testing.T) {
+	source := func(consume func(idx int, instance []float64) error) (int, error) {
+		for idx, instance := range [][]float64{{1, 2, -1}, {2, math.NaN()}} {
+			if err := consume(idx, instance); err != nil {
+				return idx, err
+			}
+		}
+		return 2, nil
+	}
+	if _, err := trainInstances(source, newInstanceValidator(skipPolicy)); err == nil {
+		t.Errorf("training without accepted instances succeeds")
+	}
+}
+
+func TestInvalidInstancesPositions(t *testing.T) {
+	tests := []struct {
+		contentType string
+		data        string
+		want        []int
+	}{
+		{"text/csv", "x,y\n1,2\n\n3,NaN\n4,5,-1\n5,6\n", []int{4, 5}},
+		{"text/tab-separated-values", "1\t2\t-1\n2\t3\n", []int{1}},
+		{"application/json", "[[1, 2], [2, 3, -1], [3, 4]]", []int{1}},
+	}
+	for _, test := range tests {
+		source := func(consume func(position int, instance []float64) error) (int, error) {
+			return decodeTrainingInstances(test.contentType, delimitedFormat{}, strings.NewReader(test.data), "", consume)
+		}
+		results, err := trainInstances(source, newInstanceValidator(skipPolicy))
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.contentType, err)
+			continue
+		}
+		if !reflect.DeepEqual(results.InvalidInstances, test.want) {
+			t.Errorf("%v: invalid instances %v, want %v", test.contentType, results.InvalidInstances, test.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// WindowStats stores the execution statistics for the requests received during a recent time window.
+type WindowStats struct {
+	// Window stores the window length: 1m, 5m or 1h.
+	Window string
+
+	// TotalRequests stores the number of requests received within the window.
+	TotalRequests int
+
+	// FailedRequests stores the number of requests failed within the window.
+	FailedRequests int
+
+	// RequestRate stores the number of requests received per second.
+	RequestRate float64
+
+	// ErrorRate stores the share of failed requests.
+	ErrorRate float64
+
+	// LatencyP50, LatencyP95 and LatencyP99 store the request processing latency percentiles in milliseconds.
+	LatencyP50 float64
+	LatencyP95 float64
+	LatencyP99 float64
+}
+
+type statsWindow struct {
+	name     string
+	duration time.Duration
+}
+
+var statsWindows = []statsWindow{
+	{name: "1m", duration: time.Minute},
+	{name: "5m", duration: 5 * time.Minute},
+	{name: "1h", duration: time.Hour},
+}
+
+const (
+	// Windows are aggregated from buckets, so they are precise up to the bucket duration.
+	windowBucketDuration = 10 * time.Second
+	windowBucketsCount   = int64(time.Hour / windowBucketDuration)
+
+	// Latency histogram bucket bounds grow exponentially: 0.1ms * 2^(i/4), up to ~100s.
+	latencyBucketsPerOctave = 4
+	latencyBucketsCount     = 81
+	minLatencyBound         = 100 * time.Microsecond
+)
+
+type windowBucket struct {
+	index int64
+
+	totalRequests  int
+	failedRequests int
+	latencies      [latencyBucketsCount]int
+}
+
+// windowedStats keeps the execution statistics for the last hour split into short buckets.
+type windowedStats struct {
+	started time.Time
+	buckets [windowBucketsCount]windowBucket
+}
+
+func newWindowedStats() *windowedStats {
+	return &windowedStats{started: time.Now()}
+}
+
+func latencyBucket(latency time.Duration) int {
+	if latency <= minLatencyBound {
+		return 0
+	}
+	idx := int(math.Ceil(latencyBucketsPerOctave * math.Log2(float64(latency) / float64(minLatencyBound))))
+	if idx >= latencyBucketsCount {
+		return latencyBucketsCount - 1
+	}
+	return idx
+}
+
+func latencyBound(bucket int) time.Duration {
+	return time.Duration(float64(minLatencyBound) * math.Exp2(float64(bucket) / latencyBucketsPerOctave))
+}
+
+func bucketIndex(moment time.Time) int64 {
+	return moment.UnixNano() / int64(windowBucketDuration)
+}
+
+func (ws *windowedStats) add(moment time.Time, succeeded bool, latency time.Duration) {
+	index := bucketIndex(moment)
+	bucket := &ws.buckets[index % windowBucketsCount]
+	if bucket.index != index {
+		*bucket = windowBucket{index: index}
+	}
+
+	bucket.totalRequests++
+	if !succeeded {
+		bucket.failedRequests++
+	}
+	bucket.latencies[latencyBucket(latency)]++
+}
+
+func (b *windowBucket) merge(other *windowBucket) {
+	b.totalRequests += other.totalRequests
+	b.failedRequests += other.failedRequests
+	for i := range b.latencies {
+		b.latencies[i] += other.latencies[i]
+	}
+}
+
+// percentile returns the upper bound of the latency bucket containing the given quantile, in milliseconds.
+func (b *windowBucket) percentile(quantile float64) float64 {
+	if b.totalRequests == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(quantile * float64(b.totalRequests)))
+	seen := 0
+	for i, count := range b.latencies {
+		seen += count
+		if seen >= rank {
+			return float64(latencyBound(i)) / float64(time.Millisecond)
+		}
+	}
+	return float64(latencyBound(latencyBucketsCount - 1)) / float64(time.Millisecond)
+}
+
+func (ws *windowedStats) get(now time.Time) []WindowStats {
+	current := bucketIndex(now)
+	uptime := now.Sub(ws.started)
+
+	var result []WindowStats
+	for _, window := range statsWindows {
+		var merged windowBucket
+		for index := current - int64(window.duration / windowBucketDuration) + 1; index <= current; index++ {
+			if bucket := &ws.buckets[index % windowBucketsCount]; bucket.index == index {
+				merged.merge(bucket)
+			}
+		}
+
+		duration := window.duration
+		if uptime < duration {
+			duration = uptime
+		}
+
+		windowStats := WindowStats{
+			Window:         window.name,
+			TotalRequests:  merged.totalRequests,
+			FailedRequests: merged.failedRequests,
+			LatencyP50:     merged.percentile(0.5),
+			LatencyP95:     merged.percentile(0.95),
+			LatencyP99:     merged.percentile(0.99),
+		}
+		if duration > 0 {
+			windowStats.RequestRate = float64(merged.totalRequests) / duration.Seconds()
+		}
+		if merged.totalRequests > 0 {
+			windowStats.ErrorRate = float64(merged.failedRequests) / float64(merged.totalRequests)
+		}
+		result = append(result, windowStats)
+	}
+	return result
+}
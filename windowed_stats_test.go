@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowedStats(t *testing.T) {
+	now := time.Now()
+	ws := &windowedStats{started: now.Add(-2 * time.Hour)}
+	for i := 0; i < 90; i++ {
+		ws.add(now, true, time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		ws.add(now, false, time.Second)
+	}
+	ws.add(now.Add(-10 * time.Minute), true, time.Millisecond)
+	ws.add(now.Add(-90 * time.Minute), true, time.Millisecond)
+
+	stats := ws.get(now)
+	if len(stats) != len(statsWindows) {
+		t.Fatalf("got %v windows, want %v", len(stats), len(statsWindows))
+	}
+	wantTotals := map[string]int{"1m": 100, "5m": 100, "1h": 101}
+	for _, window := range stats {
+		if window.TotalRequests != wantTotals[window.Window] || window.FailedRequests != 10 {
+			t.Errorf("%v: %v requests, %v failed; want %v and 10", window.Window, window.TotalRequests, window.FailedRequests, wantTotals[window.Window])
+		}
+	}
+
+	minute := stats[0]
+	if minute.ErrorRate != 0.1 {
+		t.Errorf("error rate %v, want 0.1", minute.ErrorRate)
+	}
+	if minute.RequestRate != 100.0 / 60 {
+		t.Errorf("request rate %v, want %v", minute.RequestRate, 100.0 / 60)
+	}
+	if minute.LatencyP50 < 1 || minute.LatencyP50 > 1.2 {
+		t.Errorf("p50 latency %vms, want about 1ms", minute.LatencyP50)
+	}
+	if minute.LatencyP99 < 1000 || minute.LatencyP99 > 1200 {
+		t.Errorf("p99 latency %vms, want about 1000ms", minute.LatencyP99)
+	}
+}
+
+func TestWindowedStatsRequestRateDuringStartup(t *testing.T) {
+	now := time.Now()
+	ws := &windowedStats{started: now.Add(-10 * time.Second)}
+	for i := 0; i < 20; i++ {
+		ws.add(now, true, time.Millisecond)
+	}
+	if rate := ws.get(now)[0].RequestRate; rate != 2 {
+		t.Errorf("request rate %v, want 2 over the 10s uptime", rate)
+	}
+}
+
+func TestPercentileOfEmptyBucket(t *testing.T) {
+	var bucket windowBucket
+	if p := bucket.percentile(0.99); p != 0 {
+		t.Errorf("percentile of no requests is %v, want 0", p)
+	}
+}